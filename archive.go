@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveRecord is the JSON sidecar of an archived version. The files are
+// stored in a directory named after the sha256 of the first file, the sidecar
+// under records/<host>/<feed>/<group>/<name>/<version>.json, so versions with
+// the same content do not overwrite each other's sidecar.
+type archiveRecord struct {
+	URL     string        `json:"url"`
+	Feed    string        `json:"feed"`
+	Type    string        `json:"type"`
+	Group   string        `json:"group"`
+	Name    string        `json:"name"`
+	Version string        `json:"version"`
+	Hash    string        `json:"hash"`
+	Files   []archiveFile `json:"files"`
+	Deleted time.Time     `json:"deleted"`
+}
+
+type archiveFile struct {
+	Name string   `json:"name"`
+	Hash fileHash `json:"hash"`
+}
+
+// archiveVersion downloads a version from the destination of the chain into
+// the archive directory. The files of the driver download are kept together,
+// so helper files the upload needs are restored too.
+func archiveVersion(ctx context.Context, dir string, driver FeedDriver, chain SyncChain, pkg Package, version string, timeoutConfig TimeoutConfig) (*archiveRecord, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(dir, ".download-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := driver.Download(ctx, chain.Destination, pkg, version, tmp, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 || files[0].Hash.SHA256 == "" {
+		return nil, fmt.Errorf("nothing to archive for %s/%s:%s", pkg.Group, pkg.Name, version)
+	}
+
+	record := &archiveRecord{
+		URL:     chain.Destination.URL,
+		Feed:    chain.Destination.Feed,
+		Type:    chain.Type,
+		Group:   pkg.Group,
+		Name:    pkg.Name,
+		Version: version,
+		Hash:    files[0].Hash.SHA256,
+	}
+	for _, file := range files {
+		name, err := filepath.Rel(tmp, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		record.Files = append(record.Files, archiveFile{Name: name, Hash: file.Hash})
+	}
+
+	target := filepath.Join(dir, record.Hash)
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		err = os.Rename(tmp, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Archive").Msgf("Archived %s/%s:%s to %s", pkg.Group, pkg.Name, version, target)
+	return record, nil
+}
+
+const archiveRecordsDir = "records"
+
+// sidecarPath returns the sidecar of the version in the archive directory.
+// Every part is escaped, so a group with slashes becomes one directory per
+// segment and other parts cannot add or remove levels.
+func (record *archiveRecord) sidecarPath(dir string) string {
+	host := record.URL
+	if parsedURL, err := url.Parse(record.URL); err == nil && parsedURL.Host != "" {
+		host = parsedURL.Host
+	}
+	parts := []string{dir, archiveRecordsDir, url.PathEscape(host), url.PathEscape(record.Feed)}
+	if record.Group != "" {
+		for _, segment := range strings.Split(record.Group, "/") {
+			parts = append(parts, url.PathEscape(segment))
+		}
+	}
+	parts = append(parts, url.PathEscape(record.Name), url.PathEscape(record.Version)+".json")
+	return filepath.Join(parts...)
+}
+
+// save writes the sidecar after the version is deleted from the feed.
+func (record *archiveRecord) save(dir string) error {
+	record.Deleted = time.Now()
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := record.sidecarPath(dir)
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+func readArchiveRecord(path string) (*archiveRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record archiveRecord
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &record, nil
+}
+
+// findArchiveRecord reads the sidecar ref points to and returns it with its
+// archive directory. ref is a path to the sidecar, or the sha256 of the first
+// file looked up in the archive directories of the config; of several versions
+// with this content the last deleted one is restored.
+func findArchiveRecord(config *Config, ref string) (*archiveRecord, string, error) {
+	if info, err := os.Stat(ref); err == nil && !info.IsDir() {
+		record, err := readArchiveRecord(ref)
+		if err != nil {
+			return nil, "", err
+		}
+		// the archive directory is the parent of records
+		dir := filepath.Dir(ref)
+		for dir != filepath.Dir(dir) && filepath.Base(dir) != archiveRecordsDir {
+			dir = filepath.Dir(dir)
+		}
+		if filepath.Base(dir) != archiveRecordsDir {
+			return nil, "", fmt.Errorf("%s is not in the %s directory of an archive", ref, archiveRecordsDir)
+		}
+		return record, filepath.Dir(dir), nil
+	}
+
+	var (
+		found    *archiveRecord
+		foundDir string
+	)
+	for _, dir := range archiveDirs(config) {
+		err := filepath.Walk(filepath.Join(dir, archiveRecordsDir), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != ".json" {
+				return nil
+			}
+			record, err := readArchiveRecord(path)
+			if err != nil {
+				return err
+			}
+			if record.Hash == ref && (found == nil || record.Deleted.After(found.Deleted)) {
+				found, foundDir = record, dir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if found == nil {
+		return nil, "", fmt.Errorf("archived version %s not found", ref)
+	}
+	return found, foundDir, nil
+}
+
+func archiveDirs(config *Config) []string {
+	var dirs []string
+	for _, dir := range append([]string{config.Retention.Archive}, chainArchiveDirs(config)...) {
+		if dir != "" && !containsString(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func chainArchiveDirs(config *Config) []string {
+	var dirs []string
+	for _, chain := range config.SyncChain {
+		dirs = append(dirs, config.forChain(chain).Retention.Archive)
+	}
+	return dirs
+}
+
+// restoreArchived uploads an archived version to the feed it was deleted from,
+// the API key is taken from the chain with this feed as destination.
+func restoreArchived(ctx context.Context, config *Config, ref string) error {
+	record, dir, err := findArchiveRecord(config, ref)
+	if err != nil {
+		return err
+	}
+
+	var chain *SyncChain
+	for i := range config.SyncChain {
+		for _, destination := range config.SyncChain[i].Destinations {
+			if destination.URL == record.URL && destination.Feed == record.Feed && config.SyncChain[i].Type == record.Type {
+				single := config.SyncChain[i].withDestination(destination)
+				chain = &single
+				break
+			}
+		}
+		if chain != nil {
+			break
+		}
+	}
+	if chain == nil {
+		return fmt.Errorf("no %s chain with destination %s/%s in config", record.Type, record.URL, record.Feed)
+	}
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+
+	// uploads remove the uploaded files, so the archive is copied first
+	tmp, err := os.MkdirTemp("", "restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = copyDir(filepath.Join(dir, record.Hash), tmp)
+	if err != nil {
+		return err
+	}
+	var files []transferFile
+	for _, file := range record.Files {
+		files = append(files, transferFile{Path: filepath.Join(tmp, file.Name), Hash: file.Hash})
+	}
+
+	// the record has the group and name of the destination, they are not remapped again
+	restoreChain := *chain
+	restoreChain.Remap = nil
+	pkg := Package{Group: record.Group, Name: record.Name}
+	log.Info().Str("url", record.URL).Str("feed", record.Feed).Str("Action", "Restore").Msgf("Restore %s/%s:%s from %s", record.Group, record.Name, record.Version, dir)
+	err = driver.Upload(ctx, restoreChain, pkg, record.Version, files, config.forChain(*chain).Timeout)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("url", record.URL).Str("feed", record.Feed).Str("Action", "Restore").Msgf("Restored %s/%s:%s", record.Group, record.Name, record.Version)
+	return nil
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestArchiveSidecarPerVersion(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		uploaded []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// every version has the same content
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("payload"))
+		case http.MethodPut:
+			io.ReadAll(r.Body)
+			mu.Lock()
+			uploaded = append(uploaded, r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	chain := SyncChain{Type: "upack", Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "upack"}}
+	chain.Destinations = []ProgetConfig{chain.Destination}
+	config := &Config{
+		Timeout:   TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1},
+		Retention: RetentionConfig{Enabled: true, Archive: filepath.Join(dir, "archive")},
+		SyncChain: []SyncChain{chain},
+	}
+	driver, _ := getFeedDriver("upack")
+
+	var records []*archiveRecord
+	for _, pkg := range []Package{{Group: "a/b", Name: "app"}, {Group: "", Name: "app"}, {Group: "a", Name: "b/app"}} {
+		for _, version := range []string{"1.0.0", "2.0.0"} {
+			record, err := archiveVersion(context.Background(), config.Retention.Archive, driver, chain, pkg, version, config.Timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = record.save(config.Retention.Archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, record := range records {
+		path := record.sidecarPath(config.Retention.Archive)
+		if paths[path] {
+			t.Fatalf("versions share the sidecar %s", path)
+		}
+		paths[path] = true
+		saved, archive, err := findArchiveRecord(config, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Group != record.Group || saved.Name != record.Name || saved.Version != record.Version || archive != config.Retention.Archive {
+			t.Errorf("sidecar %s holds %s/%s:%s in %s", path, saved.Group, saved.Name, saved.Version, archive)
+		}
+	}
+
+	// by content hash the last deleted version is restored
+	record, _, err := findArchiveRecord(config, records[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := records[len(records)-1]
+	if record.Group != last.Group || record.Name != last.Name || record.Version != last.Version {
+		t.Errorf("restored %s/%s:%s by hash, want the last deleted %s/%s:%s", record.Group, record.Name, record.Version, last.Group, last.Name, last.Version)
+	}
+
+	// the archived group is the remapped one, restore uploads the file as it is
+	config.SyncChain[0].Remap = []RemapRule{{Group: "^a/b$", ToGroup: "c"}}
+	compileRemap(config.SyncChain[0].Remap)
+	err = restoreArchived(context.Background(), config, records[0].sidecarPath(config.Retention.Archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 1 {
+		t.Errorf("uploaded %v, want one restore", uploaded)
+	}
+}
+
+func TestArchiveOnlyRetentionDeletes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	chain := SyncChain{Type: "upack", Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "upack"}}
+	config := &Config{
+		Timeout:     TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1},
+		Retention:   RetentionConfig{Enabled: true, Archive: filepath.Join(dir, "archive")},
+		DeleteQueue: DeleteQueueConfig{File: filepath.Join(dir, "delete-queue.json")},
+	}
+	queue, err := loadDeleteQueue(config.DeleteQueue.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, kind := range []string{deleteKindRetention, deleteKindMirror, deleteKindMismatch} {
+		queue.Items = append(queue.Items, deleteQueueItem{URL: server.URL, Feed: "dest", Group: "g", Name: "app", Version: fmt.Sprintf("%d.0.0", i+1), Kind: kind})
+	}
+
+	err = queue.flush(context.Background(), config, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Items) != 0 {
+		t.Fatalf("%d versions still queued", len(queue.Items))
+	}
+	for i, kind := range []string{deleteKindRetention, deleteKindMirror, deleteKindMismatch} {
+		record := &archiveRecord{URL: server.URL, Feed: "dest", Group: "g", Name: "app", Version: fmt.Sprintf("%d.0.0", i+1)}
+		_, err := os.Stat(record.sidecarPath(config.Retention.Archive))
+		if archived := err == nil; archived != (kind == deleteKindRetention) {
+			t.Errorf("%s deletion archived: %v", kind, archived)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assetDriver syncs files of asset directories. Every file is a package with the single version "0".
+type assetDriver struct{}
+
+func (assetDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getProgetPackages(ctx, feed, timeoutConfig)
+}
+
+// CompareVersions keeps the single version "0" of assets.
+func (assetDriver) CompareVersions(a, b string) int {
+	return 0
+}
+
+func (assetDriver) IsPrerelease(version string) bool {
+	return false
+}
+
+func (assetDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	downloadURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/content/%s", feed.URL, feed.Feed, pkg.Name))
+	filePath := filepath.Join(dir, filepath.Base(pkg.Name))
+	hash, err := downloadWithRetries(ctx, downloadURL, filePath, feed, timeoutConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+func (assetDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	uploadURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/content/%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name))
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, putRequest)
+}
+
+// Hash compares the sha1 of the asset metadata of both feeds.
+func (assetDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	srcHashURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/metadata/%s", chain.Source.URL, chain.Source.Feed, pkg.Name))
+	destHashURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/metadata/%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name))
+	SrcHash, err := getPackageHash(ctx, srcHashURL, chain.Source.APIKey, chain.Source.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+	DestHash, err := getPackageHash(ctx, destHashURL, chain.Destination.APIKey, chain.Destination.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+	return SrcHash, DestHash, nil
+}
+
+// FeedHash reads the SHA-1 of the asset metadata.
+func (assetDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	hashURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/metadata/%s", feed.URL, feed.Feed, pkg.Name))
+	hash, err := getPackageHash(ctx, hashURL, feed.APIKey, feed.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+	return "sha1", strings.ToLower(hash), err
+}
+
+func (assetDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	deleteURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/delete/%s", feed.URL, feed.Feed, pkg.Name))
+	return deleteFile(ctx, deleteURL, feed.APIKey, feed.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+}
+
+func fetchAssets(client *http.Client, url string, parentPath, apiKey string) ([]Asset, error) {
+	var allAssets []Asset
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("X-ApiKey", apiKey)
+	resp, err := client.Do(req)
+	if resp != nil {
+		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
+	} else {
+		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": "deadline", "method": req.Method}).Inc()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+
+		}
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching assets: %v", resp.Status)
+	}
+
+	var assets []Asset
+	err = json.Unmarshal(body, &assets)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, asset := range assets {
+		fullName := parentPath + "/" + asset.Name
+		if asset.Type == "dir" {
+			subAssets, err := fetchAssets(client, url+"/"+asset.Name, fullName, apiKey)
+			if err != nil {
+				return nil, err
+			}
+			allAssets = append(allAssets, subAssets...)
+		} else {
+			asset.Name = fullName
+			allAssets = append(allAssets, asset)
+		}
+	}
+
+	return allAssets, nil
+}
+
+// getAssets lists the files of an asset directory with their metadata, names are full paths.
+func getAssets(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Asset, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+	return getAssetDir(ctx, client, progetConfig, timeoutConfig, "")
+}
+
+func getAssetDir(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, dir string) ([]Asset, error) {
+	dirURL := cleanURL(fmt.Sprintf("%s/endpoints/%s/dir", progetConfig.URL, progetConfig.Feed))
+	if dir != "" {
+		dirURL += "/" + dir
+	}
+	var assets []Asset
+	err, _ := getJSON(ctx, client, dirURL, progetConfig, timeoutConfig, &assets)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []Asset
+	for _, asset := range assets {
+		if dir != "" {
+			asset.Name = dir + "/" + asset.Name
+		}
+		if asset.Type == "dir" {
+			subAssets, err := getAssetDir(ctx, client, progetConfig, timeoutConfig, asset.Name)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, subAssets...)
+		} else {
+			files = append(files, asset)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checkedVersions remembers the versions present on both feeds of a
+// bidirectional chain whose hashes were compared. The check downloads the
+// version, so a version is compared again only after recheckIterations
+// iterations or when the versions of its package change on one of the feeds,
+// and conflicts stay reported in between. Versions gone from a feed are forgotten.
+var (
+	checkedVersions   = make(map[string]versionCheck)
+	checkedVersionsMu sync.Mutex
+)
+
+// recheckIterations is the number of iterations a compared version is not compared again.
+const recheckIterations = 24
+
+// syncIteration counts the iterations of the process, run increments it before the chains start.
+var syncIteration int
+
+type versionCheck struct {
+	Match      bool
+	SourceHash string
+	DestHash   string
+	// Listing is the versions of the package on both feeds at the check
+	Listing   string
+	Iteration int
+}
+
+// reverse returns the chain syncing the destination back to the source.
+func (chain SyncChain) reverse() SyncChain {
+	reversed := chain
+	reversed.Source, reversed.Destination = chain.Destination, chain.Source
+	return reversed
+}
+
+// syncBack syncs the versions missing on the source of a bidirectional chain
+// from its destination and reports the versions that differ on the two feeds.
+// Retention applies to the destination only, so versions are synced back as they are.
+func syncBack(ctx context.Context, config *Config, chain SyncChain, pool *transferPool, queue *deleteQueue, sourcePackages, destPackages []Package) error {
+	reportConflicts(chain, findConflicts(ctx, config, chain, pool, sourcePackages, destPackages))
+
+	reverse := chain.reverse()
+	reverseConfig := *config
+	reverseConfig.Retention.Enabled = false
+	syncPackages, err := getPackagesToSync(ctx, &reverseConfig, reverse, destPackages, sourcePackages)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to SyncChain packages")
+		return nil
+	}
+	log.Info().Str("feed", chain.Source.Feed).Msgf("Sync back from %s/%s", chain.Destination.URL, chain.Destination.Feed)
+	return transferPackages(ctx, &reverseConfig, reverse, pool, queue, syncPackages, nil)
+}
+
+// syncConflict is a version present on both feeds with different hashes.
+type syncConflict struct {
+	Group      string
+	Name       string
+	Version    string
+	SourceHash string
+	DestHash   string
+}
+
+// findConflicts compares the hashes of versions present on both feeds of the
+// chain. At most proceedPackageLimit * proceedPackageVersion versions are
+// downloaded in one iteration, the rest are compared in the next ones. The
+// comparisons are transferred by the pool like the versions of the chain.
+func findConflicts(ctx context.Context, config *Config, chain SyncChain, pool *transferPool, sourcePackages, destPackages []Package) []syncConflict {
+	if driver, err := getFeedDriver(chain.Type); err == nil {
+		sourcePackages, _, _ = filterPackages(chain.Filter, driver, sourcePackages)
+	}
+	destListings := make(map[string][]string)
+	for _, pkg := range destPackages {
+		destListings[pkg.Group+":"+pkg.Name] = pkg.Versions
+	}
+
+	var (
+		conflicts []syncConflict
+		mu        sync.Mutex
+	)
+	addConflict := func(conflict syncConflict) {
+		mu.Lock()
+		conflicts = append(conflicts, conflict)
+		mu.Unlock()
+	}
+	prefix := fmt.Sprintf("%s/%s|%s/%s|", chain.Source.URL, chain.Source.Feed, chain.Destination.URL, chain.Destination.Feed)
+	present := make(map[string]bool)
+	budget := config.ProceedPackageLimit * config.ProceedPackageVersion
+	group := pool.group(config.Concurrency.PerChain)
+	for _, pkg := range sourcePackages {
+		destVersions, ok := destListings[pkg.Group+":"+pkg.Name]
+		if !ok {
+			continue
+		}
+		listing := versionListing(pkg.Versions, destVersions)
+		for _, version := range pkg.Versions {
+			if !containsString(destVersions, version) {
+				continue
+			}
+			key := prefix + fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)
+			present[key] = true
+			checkedVersionsMu.Lock()
+			check, checked := checkedVersions[key]
+			checkedVersionsMu.Unlock()
+			if checked && check.Listing == listing && syncIteration-check.Iteration < recheckIterations {
+				if !check.Match {
+					addConflict(syncConflict{Group: pkg.Group, Name: pkg.Name, Version: version, SourceHash: check.SourceHash, DestHash: check.DestHash})
+				}
+				continue
+			}
+			if budget <= 0 {
+				if checked && !check.Match {
+					// still reported until it is compared again
+					addConflict(syncConflict{Group: pkg.Group, Name: pkg.Name, Version: version, SourceHash: check.SourceHash, DestHash: check.DestHash})
+				}
+				continue
+			}
+			budget--
+
+			pkg, version, listing, previous := pkg, version, listing, check
+			err := group.submit(ctx, []ProgetConfig{chain.Source, chain.Destination}, func(ctx context.Context) error {
+				sourceHash, destHash, err := compareVersionHashes(ctx, config, chain, pkg, version)
+				if err != nil {
+					log.Error().Err(err).Str("feed", chain.Destination.Feed).Str("Action", "Conflict").Msgf("Failed to compare hashes of %s/%s:%s", pkg.Group, pkg.Name, version)
+					if checked && !previous.Match {
+						addConflict(syncConflict{Group: pkg.Group, Name: pkg.Name, Version: version, SourceHash: previous.SourceHash, DestHash: previous.DestHash})
+					}
+					return nil
+				}
+				check := versionCheck{Match: sourceHash == destHash, SourceHash: sourceHash, DestHash: destHash, Listing: listing, Iteration: syncIteration}
+				checkedVersionsMu.Lock()
+				checkedVersions[key] = check
+				checkedVersionsMu.Unlock()
+				if !check.Match {
+					addConflict(syncConflict{Group: pkg.Group, Name: pkg.Name, Version: version, SourceHash: sourceHash, DestHash: destHash})
+				}
+				return nil
+			})
+			if err != nil {
+				break
+			}
+		}
+	}
+	group.wait()
+
+	// an interrupted listing does not mark all present versions
+	if ctx.Err() == nil {
+		checkedVersionsMu.Lock()
+		for key := range checkedVersions {
+			if strings.HasPrefix(key, prefix) && !present[key] {
+				delete(checkedVersions, key)
+			}
+		}
+		checkedVersionsMu.Unlock()
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return conflicts
+}
+
+// versionListing returns a digest of the versions of a package on both feeds,
+// every compared version keeps it, so it is short.
+func versionListing(sourceVersions, destVersions []string) string {
+	source := append([]string(nil), sourceVersions...)
+	dest := append([]string(nil), destVersions...)
+	sort.Strings(source)
+	sort.Strings(dest)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(source, ",")+"|"+strings.Join(dest, ","))))
+}
+
+// compareVersionHashes downloads a version from the source and returns its hash and the one the destination reports.
+func compareVersionHashes(ctx context.Context, config *Config, chain SyncChain, pkg Package, version string) (string, string, error) {
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := createTransferDir(*savePath, pkg, version)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := driver.Download(ctx, chain.Source, pkg, version, dir, config.Timeout)
+	if err != nil {
+		return "", "", err
+	}
+	return driver.Hash(ctx, chain, pkg, version, files, config.Timeout)
+}
+
+// reportConflicts logs the conflicts of a chain and sets the conflict metric.
+func reportConflicts(chain SyncChain, conflicts []syncConflict) {
+	for _, conflict := range conflicts {
+		message := fmt.Sprintf("%s/%s:%s differs on %s/%s and %s/%s, skipped", conflict.Group, conflict.Name, conflict.Version, chain.Source.URL, chain.Source.Feed, chain.Destination.URL, chain.Destination.Feed)
+		if conflict.SourceHash != "" {
+			message += fmt.Sprintf(" (hashes %s and %s)", conflict.SourceHash, conflict.DestHash)
+		}
+		log.Error().Str("feed", chain.Destination.Feed).Str("Action", "Conflict").Msg(message)
+	}
+	SyncConflicts.With(prometheus.Labels{"url": chain.Destination.URL, "feed": chain.Destination.Feed}).Set(float64(len(conflicts)))
+}
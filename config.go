@@ -8,29 +8,72 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 type Config struct {
-	SyncChain             []SyncChain     `yaml:"syncChain"`
-	Timeout               TimeoutConfig   `yaml:"timeout"`
-	ProceedPackageLimit   int             `yaml:"proceedPackageLimit"`
-	ProceedPackageVersion int             `yaml:"proceedPackageVersion"`
-	Retention             RetentionConfig `yaml:"retention"`
+	SyncChain             []SyncChain       `yaml:"syncChain"`
+	Timeout               TimeoutConfig     `yaml:"timeout"`
+	ProceedPackageLimit   int               `yaml:"proceedPackageLimit"`
+	ProceedPackageVersion int               `yaml:"proceedPackageVersion"`
+	Order                 string            `yaml:"order"`
+	Priority              []string          `yaml:"priority"`
+	Concurrency           ConcurrencyConfig `yaml:"concurrency"`
+	Retention             RetentionConfig   `yaml:"retention"`
+	DeleteQueue           DeleteQueueConfig `yaml:"deleteQueue"`
 }
 
 type SyncChain struct {
-	Source      ProgetConfig `yaml:"source"`
-	Destination ProgetConfig `yaml:"destination"`
-	Type        string       `yaml:"type"`
+	Source ProgetConfig `yaml:"source"`
+	// Sources merges several feeds in precedence order, a version is taken from
+	// the first source that has it. readConfig puts a single source here as
+	// well and sets Source to the first one.
+	Sources     []ProgetConfig `yaml:"sources"`
+	Destination ProgetConfig   `yaml:"destination"`
+	// Destinations fans the source out to several feeds, every version is
+	// downloaded once. readConfig puts a single destination here as well and
+	// sets Destination to the first one.
+	Destinations []ProgetConfig `yaml:"destinations"`
+	Type         string         `yaml:"type"`
+	Debian       DebianConfig   `yaml:"debian"`
+	// Mode is "sync" (default), "mirror", which also deletes destination
+	// versions absent on the source unless more than MirrorThreshold percent
+	// of the destination versions would be deleted, or "bidirectional", which
+	// syncs both ways. A nil MirrorThreshold is defaultMirrorThreshold, 0 is allowed.
+	Mode            string       `yaml:"mode"`
+	MirrorThreshold *float64     `yaml:"mirrorThreshold"`
+	Filter          FilterConfig `yaml:"filter"`
+	// Remap rewrites the group and name of packages on the destination, upack only.
+	Remap []RemapRule `yaml:"remap"`
+
+	// Overrides of the global blocks, see Config.forChain
+	Timeout               TimeoutConfig    `yaml:"timeout"`
+	ProceedPackageLimit   int              `yaml:"proceedPackageLimit"`
+	ProceedPackageVersion int              `yaml:"proceedPackageVersion"`
+	Order                 string           `yaml:"order"`
+	Priority              []string         `yaml:"priority"`
+	Concurrency           int              `yaml:"concurrency"`
+	Retention             *RetentionConfig `yaml:"retention"`
+}
+
+type DebianConfig struct {
+	Distributions []string `yaml:"distributions"`
+	Components    []string `yaml:"components"`
+	Architectures []string `yaml:"architectures"`
 }
 
 type ProgetConfig struct {
-	URL    string `yaml:"url"`
-	APIKey string `yaml:"apiKey"`
-	Feed   string `yaml:"feed"`
-	Type   string `yaml:"type"`
+	URL          string       `yaml:"url"`
+	APIKey       string       `yaml:"apiKey"`
+	Feed         string       `yaml:"feed"`
+	Type         string       `yaml:"type"`
+	MaxPages     int          `yaml:"maxPages"`
+	Protocol     string       `yaml:"protocol"`
+	ServiceIndex string       `yaml:"serviceIndex"`
+	Debian       DebianConfig `yaml:"-"`
 }
 
 type Package struct {
@@ -39,9 +82,17 @@ type Package struct {
 	Versions []string `yaml:"versions"`
 }
 
+type fileHash struct {
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
 type Asset struct {
-	Name string `yaml:"name"`
-	Type string `yaml:"type"`
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Modified string `yaml:"modified"`
 }
 
 type TimeoutConfig struct {
@@ -52,9 +103,81 @@ type TimeoutConfig struct {
 }
 
 type RetentionConfig struct {
-	Enabled      bool `yaml:"enabled"`
-	DryRun       bool `yaml:"dry-run"`
-	VersionLimit int  `yaml:"versionLimit"`
+	Enabled         bool     `yaml:"enabled"`
+	DryRun          bool     `yaml:"dry-run"`
+	Archive         string   `yaml:"archive"`
+	Paths           []string `yaml:"paths"`
+	RetentionPolicy `yaml:",inline"`
+	Overrides       []RetentionOverride `yaml:"overrides"`
+}
+
+// ConcurrencyConfig limits the transfers running at once: in total, per
+// ProGet host and per chain. QueueSize is the number of transfers waiting for
+// a free worker before the chains block.
+type ConcurrencyConfig struct {
+	Workers   int `yaml:"workers"`
+	PerHost   int `yaml:"perHost"`
+	PerChain  int `yaml:"perChain"`
+	QueueSize int `yaml:"queueSize"`
+}
+
+// DeleteQueueConfig limits the delete requests retention sends to one ProGet instance.
+type DeleteQueueConfig struct {
+	File   string `yaml:"file"`
+	Budget int    `yaml:"budget"`
+	Window int    `yaml:"window"`
+}
+
+// RetentionPolicy lists the rules keeping a version, a version no rule keeps is deleted.
+type RetentionPolicy struct {
+	VersionLimit   int    `yaml:"versionLimit"`
+	KeepDays       int    `yaml:"keepDays"`
+	KeepStable     int    `yaml:"keepStable"`
+	KeepPrerelease int    `yaml:"keepPrerelease"`
+	KeepRegex      string `yaml:"keepRegex"`
+}
+
+// RetentionOverride replaces the policy for packages matching the group and name patterns.
+type RetentionOverride struct {
+	Group           string `yaml:"group"`
+	Name            string `yaml:"name"`
+	RetentionPolicy `yaml:",inline"`
+}
+
+// FilterConfig limits the packages and versions a chain syncs. A package is
+// synced when it matches one of Include (or Include is empty) and none of
+// Exclude, a version when it is in the Versions range.
+type FilterConfig struct {
+	Include           []PackageFilter `yaml:"include"`
+	Exclude           []PackageFilter `yaml:"exclude"`
+	Versions          string          `yaml:"versions"`
+	ExcludePrerelease bool            `yaml:"excludePrerelease"`
+}
+
+// RemapRule rewrites packages whose group and name match the Group and Name
+// regular expressions, ToGroup and ToName may refer to their groups as $1.
+type RemapRule struct {
+	Group   string `yaml:"group"`
+	Name    string `yaml:"name"`
+	ToGroup string `yaml:"toGroup"`
+	ToName  string `yaml:"toName"`
+
+	// compiled by readConfig
+	groupRegexp *regexp.Regexp
+	nameRegexp  *regexp.Regexp
+}
+
+// PackageFilter matches packages by path.Match globs and regular expressions,
+// a package matches when every non-empty field does.
+type PackageFilter struct {
+	Group      string `yaml:"group"`
+	Name       string `yaml:"name"`
+	GroupRegex string `yaml:"groupRegex"`
+	NameRegex  string `yaml:"nameRegex"`
+
+	// compiled by readConfig
+	groupRegexp *regexp.Regexp
+	nameRegexp  *regexp.Regexp
 }
 
 func readConfig(configFile string) (*Config, error) {
@@ -75,11 +198,55 @@ func readConfig(configFile string) (*Config, error) {
 	}
 
 	for i := range config.SyncChain {
-		config.SyncChain[i].Source.URL = strings.TrimSuffix(config.SyncChain[i].Source.URL, "/")
-		config.SyncChain[i].Destination.URL = strings.TrimSuffix(config.SyncChain[i].Destination.URL, "/")
+		if len(config.SyncChain[i].Destinations) == 0 {
+			config.SyncChain[i].Destinations = []ProgetConfig{config.SyncChain[i].Destination}
+		} else if config.SyncChain[i].Destination.URL != "" {
+			return nil, fmt.Errorf("set either destination or destinations for chain %d", i+1)
+		}
+
+		if len(config.SyncChain[i].Sources) == 0 {
+			config.SyncChain[i].Sources = []ProgetConfig{config.SyncChain[i].Source}
+		} else if config.SyncChain[i].Source.URL != "" {
+			return nil, fmt.Errorf("set either source or sources for chain %d", i+1)
+		}
 
-		config.SyncChain[i].Source.Type = config.SyncChain[i].Type
-		config.SyncChain[i].Destination.Type = config.SyncChain[i].Type
+		for j := range config.SyncChain[i].Sources {
+			config.SyncChain[i].Sources[j].URL = strings.TrimSuffix(config.SyncChain[i].Sources[j].URL, "/")
+			config.SyncChain[i].Sources[j].Type = config.SyncChain[i].Type
+			config.SyncChain[i].Sources[j].Debian = config.SyncChain[i].Debian
+		}
+		config.SyncChain[i].Source = config.SyncChain[i].Sources[0]
+		for j := range config.SyncChain[i].Destinations {
+			config.SyncChain[i].Destinations[j].URL = strings.TrimSuffix(config.SyncChain[i].Destinations[j].URL, "/")
+			config.SyncChain[i].Destinations[j].Type = config.SyncChain[i].Type
+			config.SyncChain[i].Destinations[j].Debian = config.SyncChain[i].Debian
+		}
+		config.SyncChain[i].Destination = config.SyncChain[i].Destinations[0]
+
+		if config.SyncChain[i].Mode == "" {
+			config.SyncChain[i].Mode = "sync"
+		}
+	}
+	if config.Order == "" {
+		config.Order = defaultOrder
+	}
+	if config.Concurrency.Workers == 0 {
+		config.Concurrency.Workers = defaultWorkers
+	}
+	if config.Concurrency.PerHost == 0 {
+		config.Concurrency.PerHost = config.Concurrency.Workers
+	}
+	if config.Concurrency.PerChain == 0 {
+		config.Concurrency.PerChain = config.Concurrency.Workers
+	}
+	if config.Concurrency.QueueSize == 0 {
+		config.Concurrency.QueueSize = config.Concurrency.Workers
+	}
+	if config.DeleteQueue.File == "" {
+		config.DeleteQueue.File = defaultDeleteQueueFile
+	}
+	if config.DeleteQueue.Window == 0 {
+		config.DeleteQueue.Window = defaultDeleteQueueWindow
 	}
 	log.Debug().Msg("Config file read. Validating")
 
@@ -87,6 +254,10 @@ func readConfig(configFile string) (*Config, error) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Invalid configuration")
 	}
+	for i := range config.SyncChain {
+		config.SyncChain[i].Filter.compile()
+		compileRemap(config.SyncChain[i].Remap)
+	}
 
 	return &config, nil
 }
@@ -154,6 +325,47 @@ func createDeleteDirectoryContents(dir string) error {
 	return nil
 }
 
+// forChain returns the configuration a chain runs with. Timeouts and limits the
+// chain sets replace the global ones field by field, a retention block set on
+// the chain replaces the global block as a whole.
+func (config *Config) forChain(chain SyncChain) *Config {
+	effective := *config
+	if chain.Timeout.WebRequestTimeout > 0 {
+		effective.Timeout.WebRequestTimeout = chain.Timeout.WebRequestTimeout
+	}
+	if chain.Timeout.SyncTimeout > 0 {
+		effective.Timeout.SyncTimeout = chain.Timeout.SyncTimeout
+	}
+	if chain.Timeout.MaxRetries > 0 {
+		effective.Timeout.MaxRetries = chain.Timeout.MaxRetries
+	}
+	if chain.ProceedPackageLimit > 0 {
+		effective.ProceedPackageLimit = chain.ProceedPackageLimit
+	}
+	if chain.ProceedPackageVersion > 0 {
+		effective.ProceedPackageVersion = chain.ProceedPackageVersion
+	}
+	if chain.Order != "" {
+		effective.Order = chain.Order
+	}
+	if len(chain.Priority) > 0 {
+		effective.Priority = chain.Priority
+	}
+	if chain.Concurrency > 0 {
+		effective.Concurrency.PerChain = chain.Concurrency
+	}
+	if chain.Retention != nil {
+		effective.Retention = *chain.Retention
+	}
+
+	if effective.Retention.Enabled && !effective.Retention.DryRun && effective.Retention.VersionLimit > 0 {
+		if effective.ProceedPackageVersion > effective.Retention.VersionLimit {
+			effective.ProceedPackageVersion = effective.Retention.VersionLimit
+		}
+	}
+	return &effective
+}
+
 func cleanURL(url string) string {
 	parts := strings.SplitN(url, "://", 2)
 	if len(parts) != 2 {
@@ -190,22 +402,114 @@ func validateConfig(config *Config) error {
 		errorMessages = append(errorMessages, fmt.Sprintf("found 0 syncChains"))
 	}
 	for i, chain := range config.SyncChain {
-		if chain.Source.URL == "" {
-			errorMessages = append(errorMessages, fmt.Sprintf("source URL cannot be empty for chain %d", i+1))
+		for _, source := range chain.Sources {
+			if source.URL == "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("source URL cannot be empty for chain %d", i+1))
+			}
+			if source.APIKey == "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("source API key cannot be empty for chain %d", i+1))
+			}
+			if source.MaxPages < 0 {
+				errorMessages = append(errorMessages, fmt.Sprintf("maxPages cannot be negative for chain %d", i+1))
+			}
+		}
+		for _, destination := range chain.Destinations {
+			if destination.URL == "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("destination URL cannot be empty for chain %d", i+1))
+			}
+			if destination.APIKey == "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("destination API key cannot be empty for chain %d", i+1))
+			}
+			if destination.MaxPages < 0 {
+				errorMessages = append(errorMessages, fmt.Sprintf("maxPages cannot be negative for chain %d", i+1))
+			}
+		}
+		if chain.Mode == "bidirectional" && (len(chain.Sources) > 1 || len(chain.Destinations) > 1) {
+			errorMessages = append(errorMessages, fmt.Sprintf("bidirectional chain %d cannot have several sources or destinations", i+1))
+		}
+		if _, err := getFeedDriver(chain.Type); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("unknown type %q for chain %d, supported: %s", chain.Type, i+1, strings.Join(feedTypes(), ", ")))
+		}
+		if chain.Type == "debian" && len(chain.Debian.Distributions) == 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("debian.distributions cannot be empty for chain %d", i+1))
+		}
+		switch chain.Mode {
+		case "sync", "mirror", "bidirectional":
+		default:
+			errorMessages = append(errorMessages, fmt.Sprintf("unknown mode %q for chain %d, supported: sync, mirror, bidirectional", chain.Mode, i+1))
+		}
+		if chain.MirrorThreshold != nil && (*chain.MirrorThreshold < 0 || *chain.MirrorThreshold > 100) {
+			errorMessages = append(errorMessages, fmt.Sprintf("mirrorThreshold must be between 0 and 100 for chain %d", i+1))
+		}
+		if chain.ProceedPackageLimit < 0 || chain.ProceedPackageVersion < 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("proceedPackageLimit and proceedPackageVersion cannot be negative for chain %d", i+1))
+		}
+		if chain.Concurrency < 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("concurrency cannot be negative for chain %d", i+1))
 		}
-		if chain.Destination.URL == "" {
-			errorMessages = append(errorMessages, fmt.Sprintf("destination URL cannot be empty for chain %d", i+1))
+		if chain.Timeout.SyncTimeout < 0 || chain.Timeout.IterationTimeout < 0 || chain.Timeout.WebRequestTimeout < 0 || chain.Timeout.MaxRetries < 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("timeouts cannot be negative for chain %d", i+1))
 		}
-		if chain.Source.APIKey == "" {
-			errorMessages = append(errorMessages, fmt.Sprintf("source API key cannot be empty for chain %d", i+1))
+		if chain.Timeout.IterationTimeout != 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("iterationTimeout is the pause between iterations of all chains and cannot be set for chain %d", i+1))
 		}
-		if chain.Destination.APIKey == "" {
-			errorMessages = append(errorMessages, fmt.Sprintf("destination API key cannot be empty for chain %d", i+1))
+		if len(chain.Remap) > 0 && chain.Type != "upack" {
+			errorMessages = append(errorMessages, fmt.Sprintf("remap is supported only for upack in chain %d", i+1))
+		}
+		if len(chain.Remap) > 0 && chain.Mode == "bidirectional" {
+			errorMessages = append(errorMessages, fmt.Sprintf("remap is not supported for bidirectional chain %d", i+1))
+		}
+		for j, rule := range chain.Remap {
+			for _, expression := range []string{rule.Group, rule.Name} {
+				if _, err := regexp.Compile(expression); err != nil {
+					errorMessages = append(errorMessages, fmt.Sprintf("invalid regex %q in remap rule %d of chain %d: %v", expression, j+1, i+1, err))
+				}
+			}
+			if rule.ToGroup == "" && rule.ToName == "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("remap rule %d of chain %d has neither toGroup nor toName", j+1, i+1))
+			}
+		}
+		errorMessages = append(errorMessages, validateOrder(chain.Order, chain.Priority, fmt.Sprintf("chain %d", i+1))...)
+		errorMessages = append(errorMessages, validateFilter(chain.Filter, chain.Type, fmt.Sprintf("filter of chain %d", i+1))...)
+		if chain.Retention != nil {
+			errorMessages = append(errorMessages, validateRetention(*chain.Retention, fmt.Sprintf("retention of chain %d", i+1))...)
+		}
+		if retention := config.forChain(chain).Retention; retention.Enabled && chain.Type == "docker" {
+			if retention.Archive != "" {
+				errorMessages = append(errorMessages, fmt.Sprintf("retention.archive is not supported for docker chain %d", i+1))
+			}
+			// docker tags have no order, so the newest tags to keep are unknown
+			policies := []RetentionPolicy{retention.RetentionPolicy}
+			for _, override := range retention.Overrides {
+				policies = append(policies, override.RetentionPolicy)
+			}
+			for _, policy := range policies {
+				if policy.VersionLimit > 0 || policy.KeepStable > 0 || policy.KeepPrerelease > 0 {
+					errorMessages = append(errorMessages, fmt.Sprintf("retention versionLimit, keepStable and keepPrerelease are not supported for docker chain %d", i+1))
+					break
+				}
+			}
+		}
+		for _, feed := range append(append([]ProgetConfig{}, chain.Sources...), chain.Destinations...) {
+			switch feed.Protocol {
+			case "", "v2":
+			case "v3":
+				if chain.Type != "nuget" {
+					errorMessages = append(errorMessages, fmt.Sprintf("protocol v3 is supported only for nuget in chain %d", i+1))
+				}
+			default:
+				errorMessages = append(errorMessages, fmt.Sprintf("unknown protocol %q in chain %d", feed.Protocol, i+1))
+			}
 		}
 	}
 
-	if config.Retention.Enabled && config.Retention.VersionLimit <= 0 {
-		errorMessages = append(errorMessages, "invalid VersionLimit for retention: must be greater than 0")
+	errorMessages = append(errorMessages, validateOrder(config.Order, config.Priority, "config")...)
+	errorMessages = append(errorMessages, validateRetention(config.Retention, "retention")...)
+	if config.Concurrency.Workers < 0 || config.Concurrency.PerHost < 0 || config.Concurrency.PerChain < 0 || config.Concurrency.QueueSize < 0 {
+		errorMessages = append(errorMessages, "invalid concurrency: workers, perHost, perChain and queueSize cannot be negative")
+	}
+	if config.DeleteQueue.Budget < 0 || config.DeleteQueue.Window < 0 {
+		errorMessages = append(errorMessages, "invalid deleteQueue: budget and window cannot be negative")
 	}
 
 	if len(errorMessages) > 0 {
@@ -214,3 +518,80 @@ func validateConfig(config *Config) error {
 	log.Debug().Msg("Configuration validation successful")
 	return nil
 }
+
+func validateRetention(retention RetentionConfig, where string) []string {
+	if !retention.Enabled {
+		return nil
+	}
+	errorMessages := validateRetentionPolicy(retention.RetentionPolicy, where)
+	for _, pattern := range retention.Paths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("invalid path %q in %s: %v", pattern, where, err))
+		}
+	}
+	for i, override := range retention.Overrides {
+		overrideWhere := fmt.Sprintf("%s override %d", where, i+1)
+		for _, pattern := range []string{override.Group, override.Name} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errorMessages = append(errorMessages, fmt.Sprintf("invalid pattern %q in %s: %v", pattern, overrideWhere, err))
+			}
+		}
+		errorMessages = append(errorMessages, validateRetentionPolicy(override.RetentionPolicy, overrideWhere)...)
+	}
+	return errorMessages
+}
+
+func validateOrder(order string, priority []string, where string) []string {
+	var errorMessages []string
+	switch order {
+	case "", orderNewest, orderOldestMissing, orderSmallest:
+	default:
+		errorMessages = append(errorMessages, fmt.Sprintf("unknown order %q in %s, supported: %s, %s, %s", order, where, orderNewest, orderOldestMissing, orderSmallest))
+	}
+	for _, pattern := range priority {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("invalid priority pattern %q in %s: %v", pattern, where, err))
+		}
+	}
+	return errorMessages
+}
+
+func validateFilter(filter FilterConfig, chainType string, where string) []string {
+	var errorMessages []string
+	for _, packageFilter := range append(append([]PackageFilter{}, filter.Include...), filter.Exclude...) {
+		for _, pattern := range []string{packageFilter.Group, packageFilter.Name} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errorMessages = append(errorMessages, fmt.Sprintf("invalid pattern %q in %s: %v", pattern, where, err))
+			}
+		}
+		for _, expression := range []string{packageFilter.GroupRegex, packageFilter.NameRegex} {
+			if _, err := regexp.Compile(expression); err != nil {
+				errorMessages = append(errorMessages, fmt.Sprintf("invalid regex %q in %s: %v", expression, where, err))
+			}
+		}
+	}
+	if filter.Versions != "" {
+		if chainType == "docker" || chainType == "asset" {
+			errorMessages = append(errorMessages, fmt.Sprintf("invalid %s: versions is not supported for %s, its versions are not ordered", where, chainType))
+		} else if _, err := parseVersionRange(filter.Versions); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("invalid versions in %s: %v", where, err))
+		}
+	}
+	return errorMessages
+}
+
+func validateRetentionPolicy(policy RetentionPolicy, where string) []string {
+	var errorMessages []string
+	if policy.VersionLimit < 0 || policy.KeepDays < 0 || policy.KeepStable < 0 || policy.KeepPrerelease < 0 {
+		errorMessages = append(errorMessages, fmt.Sprintf("invalid %s: limits cannot be negative", where))
+	}
+	if policy.VersionLimit == 0 && policy.KeepDays == 0 && policy.KeepStable == 0 && policy.KeepPrerelease == 0 {
+		errorMessages = append(errorMessages, fmt.Sprintf("invalid %s: one of versionLimit, keepDays, keepStable, keepPrerelease must be greater than 0", where))
+	}
+	if policy.KeepRegex != "" {
+		if _, err := regexp.Compile(policy.KeepRegex); err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("invalid keepRegex in %s: %v", where, err))
+		}
+	}
+	return errorMessages
+}
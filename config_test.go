@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorThreshold(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configFile, []byte(`timeout: {webRequestTimeout: 5, iterationTimeout: 60, syncTimeout: 60, maxRetries: 1}
+proceedPackageLimit: 10
+proceedPackageVersion: 10
+syncChain:
+  - source: {url: "https://a.example.com", apiKey: k, feed: f}
+    destination: {url: "https://b.example.com", apiKey: k, feed: f}
+    type: upack
+    mode: mirror
+  - source: {url: "https://a.example.com", apiKey: k, feed: g}
+    destination: {url: "https://b.example.com", apiKey: k, feed: g}
+    type: upack
+    mode: mirror
+    mirrorThreshold: 0
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := readConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.SyncChain[0].mirrorThreshold(); got != defaultMirrorThreshold {
+		t.Errorf("mirrorThreshold without the field = %v, want %v", got, float64(defaultMirrorThreshold))
+	}
+	// an explicit 0 forbids any mirror deletion instead of falling back to the default
+	if got := config.SyncChain[1].mirrorThreshold(); got != 0 {
+		t.Errorf("mirrorThreshold: 0 = %v, want 0", got)
+	}
+}
+
+func TestReadConfigCompilesExpressions(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configFile, []byte(`timeout: {webRequestTimeout: 5, iterationTimeout: 60, syncTimeout: 60, maxRetries: 1}
+proceedPackageLimit: 10
+proceedPackageVersion: 10
+syncChain:
+  - source: {url: "https://a.example.com", apiKey: k, feed: f}
+    destination: {url: "https://b.example.com", apiKey: k, feed: f}
+    type: upack
+    filter:
+      include: [{groupRegex: "^tools$"}]
+      exclude: [{nameRegex: "-test$"}]
+    remap:
+      - {group: "^tools$", name: "^(.+)-cli$", toName: "$1"}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := readConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := config.SyncChain[0]
+	if chain.Filter.Include[0].groupRegexp == nil || chain.Filter.Exclude[0].nameRegexp == nil {
+		t.Fatal("the filter expressions are not compiled")
+	}
+	if !chain.Filter.keepPackage(Package{Group: "tools", Name: "cli"}) || chain.Filter.keepPackage(Package{Group: "tools", Name: "cli-test"}) {
+		t.Error("the compiled filter does not match as configured")
+	}
+	if remapped := chain.remap(Package{Group: "tools", Name: "build-cli"}); remapped.Name != "build" {
+		t.Errorf("the compiled remap rule renamed build-cli to %s, want build", remapped.Name)
+	}
+}
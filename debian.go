@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repoFile is a package file listed in the metadata of a debian or rpm repository.
+type repoFile struct {
+	Filename  string
+	URL       string
+	Algorithm string
+	Checksum  string
+}
+
+// repoIndex is the parsed metadata of a debian or rpm feed: the files of the
+// versions by "group:name:version" and their publish times by "group:name".
+// Indices lists the debian distribution/component/architecture triples read.
+type repoIndex struct {
+	Files     map[string]repoFile
+	Published map[string]map[string]time.Time
+	Indices   [][3]string
+}
+
+// repoIndexCache keeps the metadata the last listing of every feed parsed, so
+// downloads, hash checks and publish dates do not fetch it for every version.
+var (
+	repoIndexCache   = make(map[string]*repoIndex)
+	repoIndexCacheMu sync.Mutex
+)
+
+func newRepoIndex() *repoIndex {
+	return &repoIndex{Files: make(map[string]repoFile), Published: make(map[string]map[string]time.Time)}
+}
+
+func repoIndexKey(progetConfig ProgetConfig) string {
+	return fmt.Sprintf("%s|%s|%s", progetConfig.Type, progetConfig.URL, progetConfig.Feed)
+}
+
+func repoVersionKey(group, name, version string) string {
+	return fmt.Sprintf("%s:%s:%s", group, name, version)
+}
+
+func cachedRepoIndex(progetConfig ProgetConfig) *repoIndex {
+	repoIndexCacheMu.Lock()
+	defer repoIndexCacheMu.Unlock()
+	return repoIndexCache[repoIndexKey(progetConfig)]
+}
+
+func storeRepoIndex(progetConfig ProgetConfig, index *repoIndex) {
+	repoIndexCacheMu.Lock()
+	defer repoIndexCacheMu.Unlock()
+	repoIndexCache[repoIndexKey(progetConfig)] = index
+}
+
+// cachedRepoFile looks the version up in the cached metadata of the feed.
+func cachedRepoFile(progetConfig ProgetConfig, pkg Package, version string) (repoFile, bool) {
+	repoIndexCacheMu.Lock()
+	defer repoIndexCacheMu.Unlock()
+	index, ok := repoIndexCache[repoIndexKey(progetConfig)]
+	if !ok {
+		return repoFile{}, false
+	}
+	file, ok := index.Files[repoVersionKey(pkg.Group, pkg.Name, version)]
+	return file, ok
+}
+
+func debianRepoURL(progetConfig ProgetConfig) string {
+	return cleanURL(fmt.Sprintf("%s/debian/%s", progetConfig.URL, progetConfig.Feed))
+}
+
+// debianGroup keeps distribution, component and architecture of a package in
+// its group, so the same name in different indices is synced separately. The
+// architecture is the one of the package, "all" packages are listed in the
+// index of every architecture but are one package.
+func debianGroup(distribution, component, architecture string) string {
+	return fmt.Sprintf("%s/%s/%s", distribution, component, architecture)
+}
+
+// parseDebianControl splits a Packages or Release file into stanzas of
+// "Field: value" lines. Continuation lines of multiline fields are skipped.
+func parseDebianControl(body []byte) []map[string]string {
+	var stanzas []map[string]string
+	stanza := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(stanza) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if field, value, ok := strings.Cut(line, ":"); ok {
+			stanza[field] = strings.TrimSpace(value)
+		}
+	}
+	if len(stanza) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+	return stanzas
+}
+
+// debianIndices returns distribution/component/architecture triples to list.
+// Components and architectures not set in the config are taken from the
+// Release file of the distribution.
+func debianIndices(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([][3]string, error) {
+	var indices [][3]string
+	for _, distribution := range progetConfig.Debian.Distributions {
+		components := progetConfig.Debian.Components
+		architectures := progetConfig.Debian.Architectures
+		if len(components) == 0 || len(architectures) == 0 {
+			releaseURL := fmt.Sprintf("%s/dists/%s/Release", debianRepoURL(progetConfig), distribution)
+			body, err, _ := getBody(ctx, client, releaseURL, progetConfig, timeoutConfig)
+			if err != nil {
+				return nil, err
+			}
+			release := parseDebianControl(body)
+			if len(release) == 0 {
+				return nil, fmt.Errorf("empty release file %s", releaseURL)
+			}
+			if len(components) == 0 {
+				components = strings.Fields(release[0]["Components"])
+			}
+			if len(architectures) == 0 {
+				architectures = strings.Fields(release[0]["Architectures"])
+			}
+		}
+		for _, component := range components {
+			for _, architecture := range architectures {
+				indices = append(indices, [3]string{distribution, component, architecture})
+			}
+		}
+	}
+	return indices, nil
+}
+
+// getDebianIndex reads the Packages index of one architecture, falling back to Packages.gz.
+func getDebianIndex(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, distribution, component, architecture string) ([]map[string]string, error) {
+	indexURL := fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages", debianRepoURL(progetConfig), distribution, component, architecture)
+	body, err, statusCode := getBody(ctx, client, indexURL, progetConfig, timeoutConfig)
+	if statusCode == http.StatusNotFound {
+		body, err, _ = getBody(ctx, client, indexURL+".gz", progetConfig, timeoutConfig)
+		if err == nil {
+			body, err = gunzip(body)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseDebianControl(body), nil
+}
+
+func getDebianPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting debian packages")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	indices, err := debianIndices(ctx, client, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := newRepoIndex()
+	repo.Indices = indices
+	var packages []Package
+	positions := make(map[string]int)
+	for _, index := range indices {
+		stanzas, err := getDebianIndex(ctx, client, progetConfig, timeoutConfig, index[0], index[1], index[2])
+		if err != nil {
+			return nil, err
+		}
+		for _, stanza := range stanzas {
+			name, version := stanza["Package"], stanza["Version"]
+			if name == "" || version == "" {
+				continue
+			}
+			group := debianStanzaGroup(index, stanza)
+			key := group + ":" + name
+			i, exists := positions[key]
+			if !exists {
+				i = len(packages)
+				positions[key] = i
+				packages = append(packages, Package{
+					Group: group,
+					Name:  name,
+				})
+			}
+			if containsString(packages[i].Versions, version) {
+				continue
+			}
+			packages[i].Versions = append(packages[i].Versions, version)
+			file, err := debianRepoFile(progetConfig, stanza)
+			if err != nil {
+				return nil, err
+			}
+			repo.Files[repoVersionKey(group, name, version)] = file
+		}
+	}
+	storeRepoIndex(progetConfig, repo)
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// debianStanzaGroup returns the group of a package of the index, with the
+// Architecture field of the package or the index architecture without one.
+func debianStanzaGroup(index [3]string, stanza map[string]string) string {
+	architecture := stanza["Architecture"]
+	if architecture == "" {
+		architecture = index[2]
+	}
+	return debianGroup(index[0], index[1], architecture)
+}
+
+func debianRepoFile(progetConfig ProgetConfig, stanza map[string]string) (repoFile, error) {
+	fileURL, err := resolveURL(debianRepoURL(progetConfig)+"/", stanza["Filename"])
+	if err != nil {
+		return repoFile{}, err
+	}
+	return repoFile{
+		Filename:  filepath.Base(stanza["Filename"]),
+		URL:       fileURL,
+		Algorithm: "sha256",
+		Checksum:  strings.ToLower(stanza["SHA256"]),
+	}, nil
+}
+
+// getDebianFile finds the .deb of a version in the metadata of the last
+// listing. A version missing there, like one just uploaded, is looked up in
+// the current index the package group points to, "all" packages in the first
+// architecture of their distribution and component.
+func getDebianFile(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, pkg Package, version string) (repoFile, error) {
+	if file, ok := cachedRepoFile(progetConfig, pkg, version); ok {
+		return file, nil
+	}
+	group := strings.Split(pkg.Group, "/")
+	if len(group) != 3 {
+		return repoFile{}, fmt.Errorf("invalid debian package group %q, expected distribution/component/architecture", pkg.Group)
+	}
+	index := [3]string{group[0], group[1], group[2]}
+	if group[2] == "all" {
+		var indices [][3]string
+		if repo := cachedRepoIndex(progetConfig); repo != nil {
+			indices = repo.Indices
+		} else {
+			var err error
+			indices, err = debianIndices(ctx, client, progetConfig, timeoutConfig)
+			if err != nil {
+				return repoFile{}, err
+			}
+		}
+		index[2] = ""
+		for _, listed := range indices {
+			if listed[0] == group[0] && listed[1] == group[1] {
+				index[2] = listed[2]
+				break
+			}
+		}
+		if index[2] == "" {
+			return repoFile{}, fmt.Errorf("no architecture index known for %s/%s:%s", pkg.Group, pkg.Name, version)
+		}
+	}
+
+	stanzas, err := getDebianIndex(ctx, client, progetConfig, timeoutConfig, index[0], index[1], index[2])
+	if err != nil {
+		return repoFile{}, err
+	}
+	for _, stanza := range stanzas {
+		if stanza["Package"] != pkg.Name || stanza["Version"] != version || debianStanzaGroup(index, stanza) != pkg.Group {
+			continue
+		}
+		return debianRepoFile(progetConfig, stanza)
+	}
+	return repoFile{}, fmt.Errorf("%s/%s:%s not found in %s", pkg.Group, pkg.Name, version, debianRepoURL(progetConfig))
+}
+
+type debianDriver struct{}
+
+func (debianDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getDebianPackages(ctx, feed, timeoutConfig)
+}
+
+func (debianDriver) CompareVersions(a, b string) int {
+	return compareDebianVersions(a, b)
+}
+
+func (debianDriver) IsPrerelease(version string) bool {
+	return isTildePrerelease(version)
+}
+
+func (debianDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	file, err := getDebianFile(ctx, client, feed, timeoutConfig, pkg, version)
+	if err != nil {
+		return nil, err
+	}
+	return downloadRepoFile(ctx, feed, file, dir, timeoutConfig)
+}
+
+// Upload puts the .deb into the distribution and component of the package group.
+func (debianDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	index := strings.Split(pkg.Group, "/")
+	if len(index) != 3 {
+		return fmt.Errorf("invalid debian package group %q, expected distribution/component/architecture", pkg.Group)
+	}
+	uploadURL := fmt.Sprintf("%s/upload/%s/%s", debianRepoURL(chain.Destination), index[0], index[1])
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, putRequest)
+}
+
+func (debianDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	dest, err := getDebianFile(ctx, client, chain.Destination, timeoutConfig, pkg, version)
+	if err != nil {
+		return "", "", err
+	}
+	log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Success get hash %s/%s:%s. %s: %s", pkg.Group, pkg.Name, version, dest.Algorithm, dest.Checksum)
+	return repoFileChecksum(files[0].Hash, dest.Algorithm), dest.Checksum, nil
+}
+
+// FeedHash reads the SHA256 of the package index.
+func (debianDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	file, err := getDebianFile(ctx, client, feed, timeoutConfig, pkg, version)
+	if err != nil {
+		return "", "", err
+	}
+	return file.Algorithm, file.Checksum, nil
+}
+
+// Delete removes the package of one architecture, identified by purl. The
+// group ends with the Architecture field of the package, "all" for packages
+// of every architecture.
+func (debianDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	group := strings.Split(pkg.Group, "/")
+	purl := fmt.Sprintf("pkg:deb/%s@%s?arch=%s", pkg.Name, url.QueryEscape(version), group[len(group)-1])
+	return deletePackage(ctx, feed, pkg, version, url.Values{"purl": {purl}}, timeoutConfig)
+}
+
+// downloadRepoFile downloads a package file of a debian or rpm repository and
+// checks it against the checksum of the repository metadata.
+func downloadRepoFile(ctx context.Context, feed ProgetConfig, file repoFile, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	filePath := filepath.Join(dir, file.Filename)
+	hash, err := downloadWithRetries(ctx, file.URL, filePath, feed, timeoutConfig, func(hash fileHash) error {
+		if actual := repoFileChecksum(hash, file.Algorithm); file.Checksum != "" && actual != file.Checksum {
+			return fmt.Errorf("%s mismatch for %s: metadata %s, downloaded %s", file.Algorithm, file.Filename, file.Checksum, actual)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+// repoFileChecksum picks the downloaded hash matching the algorithm named in repository metadata.
+func repoFileChecksum(hash fileHash, algorithm string) string {
+	switch strings.ToLower(algorithm) {
+	case "sha", "sha1":
+		return hash.SHA1
+	case "sha512":
+		return hash.SHA512
+	case "md5":
+		return hash.MD5
+	}
+	return hash.SHA256
+}
+
+func gunzip(body []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeleteQueueFile   = "delete-queue.json"
+	defaultDeleteQueueWindow = 3600
+)
+
+// Kinds of queued deletions, every kind is rebuilt by its own step.
+const (
+	deleteKindRetention = "retention"
+	deleteKindMirror    = "mirror"
+	deleteKindMismatch  = "mismatch"
+)
+
+// deleteQueue keeps the versions retention decided to delete and the delete
+// budget of every ProGet instance. It is stored in a JSON file, so deletions
+// the budget does not allow in one iteration continue in the next ones and
+// after a restart. Hash mismatches found by the transfers of parallel chains
+// are queued while retention runs, so every change holds mu.
+type deleteQueue struct {
+	path      string
+	mu        sync.Mutex
+	Items     []deleteQueueItem        `json:"items"`
+	Instances map[string]*deleteBudget `json:"instances"`
+}
+
+// deleteQueueItem is a version waiting for deletion. API keys are not
+// stored, the item is deleted with the destination of the chain of its feed.
+type deleteQueueItem struct {
+	Kind    string    `json:"kind"`
+	URL     string    `json:"url"`
+	Feed    string    `json:"feed"`
+	Group   string    `json:"group"`
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Reason  string    `json:"reason"`
+	Queued  time.Time `json:"queued"`
+}
+
+// deleteBudget counts delete requests sent to an instance in the current window.
+type deleteBudget struct {
+	WindowStart  time.Time `json:"windowStart"`
+	Used         int       `json:"used"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+}
+
+// rateLimitError is returned for 429 responses with the delay from Retry-After, 0 when it is absent.
+type rateLimitError struct {
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *rateLimitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return e.err
+}
+
+func newRateLimitError(resp *http.Response, err error) error {
+	rateLimit := &rateLimitError{err: err}
+	value := resp.Header.Get("Retry-After")
+	if seconds, parseErr := strconv.Atoi(value); parseErr == nil {
+		rateLimit.RetryAfter = time.Duration(seconds) * time.Second
+	} else if date, parseErr := http.ParseTime(value); parseErr == nil {
+		rateLimit.RetryAfter = time.Until(date)
+	}
+	return rateLimit
+}
+
+// loadDeleteQueue reads the queue file, a missing file is an empty queue.
+func loadDeleteQueue(path string) (*deleteQueue, error) {
+	queue := &deleteQueue{path: path, Instances: make(map[string]*deleteBudget)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		return queue, err
+	}
+	err = json.Unmarshal(data, queue)
+	if err != nil {
+		return &deleteQueue{path: path, Instances: make(map[string]*deleteBudget)}, fmt.Errorf("failed to decode delete queue %s: %w", path, err)
+	}
+	if queue.Instances == nil {
+		queue.Instances = make(map[string]*deleteBudget)
+	}
+	for i := range queue.Items {
+		if queue.Items[i].Kind == "" {
+			queue.Items[i].Kind = deleteKindRetention
+		}
+	}
+	return queue, nil
+}
+
+// save writes the queue to a temporary file first, so an interrupted write keeps the previous queue.
+func (q *deleteQueue) save() error {
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
+
+// replace sets the queued versions of one kind for a feed to items. Versions
+// queued before keep their place and queue time, versions no longer in items are dropped.
+func (q *deleteQueue) replace(feed ProgetConfig, kind string, items []deleteQueueItem) {
+	wanted := make(map[string]deleteQueueItem, len(items))
+	for _, item := range items {
+		wanted[item.key()] = item
+	}
+
+	var queued []deleteQueueItem
+	for _, item := range q.Items {
+		if item.URL != feed.URL || item.Feed != feed.Feed || item.Kind != kind {
+			queued = append(queued, item)
+			continue
+		}
+		if current, ok := wanted[item.key()]; ok {
+			item.Reason = current.Reason
+			queued = append(queued, item)
+			delete(wanted, item.key())
+		}
+	}
+	for _, item := range items {
+		if _, ok := wanted[item.key()]; ok {
+			queued = append(queued, item)
+		}
+	}
+	q.Items = queued
+	q.updateDepth(feed)
+}
+
+// queuedVersions returns the versions of a package queued for deletion on the feed.
+func (q *deleteQueue) queuedVersions(feed ProgetConfig, group, name string) map[string]bool {
+	versions := make(map[string]bool)
+	for _, item := range q.Items {
+		if item.URL == feed.URL && item.Feed == feed.Feed && item.Group == group && item.Name == name {
+			versions[item.Version] = true
+		}
+	}
+	return versions
+}
+
+func (item deleteQueueItem) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", item.Kind, item.URL, item.Feed, item.Group, item.Name, item.Version)
+}
+
+func (q *deleteQueue) updateDepth(feed ProgetConfig) {
+	depth := 0
+	for _, item := range q.Items {
+		if item.URL == feed.URL && item.Feed == feed.Feed {
+			depth++
+		}
+	}
+	DeleteQueueDepth.With(prometheus.Labels{"url": feed.URL, "feed": feed.Feed}).Set(float64(depth))
+}
+
+// take reserves one request of the instance budget, it returns false when the
+// budget of the window is spent or the instance asked to wait.
+func (q *deleteQueue) take(config *Config, instance string, now time.Time) bool {
+	budget, ok := q.Instances[instance]
+	if !ok {
+		budget = &deleteBudget{WindowStart: now}
+		q.Instances[instance] = budget
+	}
+	if now.Before(budget.BlockedUntil) {
+		log.Info().Str("url", instance).Str("Action", "Retention").Msgf("Delete requests paused until %s", budget.BlockedUntil.Format(time.RFC3339))
+		return false
+	}
+	window := time.Duration(config.DeleteQueue.Window) * time.Second
+	if now.Sub(budget.WindowStart) >= window {
+		budget.WindowStart = now
+		budget.Used = 0
+	}
+	if config.DeleteQueue.Budget > 0 && budget.Used >= config.DeleteQueue.Budget {
+		budget.BlockedUntil = budget.WindowStart.Add(window)
+		log.Info().Str("url", instance).Str("Action", "Retention").Msgf("Delete budget of %d requests is spent, next requests at %s", config.DeleteQueue.Budget, budget.BlockedUntil.Format(time.RFC3339))
+		return false
+	}
+	budget.Used++
+	return true
+}
+
+// commit replaces the queued versions of one kind for the chain destination
+// with items, sends the deletions the budget allows and saves the queue.
+func (q *deleteQueue) commit(ctx context.Context, config *Config, chain SyncChain, kind string, items []deleteQueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range items {
+		items[i].Kind = kind
+	}
+	q.replace(chain.Destination, kind, items)
+	err := q.process(ctx, config, chain)
+	if err != nil {
+		return err
+	}
+	return q.save()
+}
+
+// push queues one version of the chain destination in addition to the queued
+// ones of its kind, sends the deletions the budget allows and saves the queue.
+func (q *deleteQueue) push(ctx context.Context, config *Config, chain SyncChain, kind string, item deleteQueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item.Kind = kind
+	queued := false
+	for _, current := range q.Items {
+		if current.key() == item.key() {
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		q.Items = append(q.Items, item)
+	}
+	err := q.process(ctx, config, chain)
+	if err != nil {
+		return err
+	}
+	return q.save()
+}
+
+// flush sends the queued deletions of the chain destination the budget allows
+// and saves the queue, a queue without versions of the destination is left as is.
+func (q *deleteQueue) flush(ctx context.Context, config *Config, chain SyncChain) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := false
+	for _, item := range q.Items {
+		if item.URL == chain.Destination.URL && item.Feed == chain.Destination.Feed {
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		return nil
+	}
+	err := q.process(ctx, config, chain)
+	if err != nil {
+		return err
+	}
+	return q.save()
+}
+
+// clear drops the queued versions of one kind for the feed and saves the queue.
+func (q *deleteQueue) clear(feed ProgetConfig, kind string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.replace(feed, kind, nil)
+	return q.save()
+}
+
+// dockerTagDigests is the tag to digest map of a repository or the error reading it.
+type dockerTagDigests struct {
+	digests map[string]string
+	err     error
+}
+
+// process deletes queued versions of the chain destination, oldest first, until
+// the budget is spent or the instance refuses. Failed deletions stay queued.
+func (q *deleteQueue) process(ctx context.Context, config *Config, chain SyncChain) error {
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+	feed := chain.Destination
+	defer q.updateDepth(feed)
+
+	var (
+		queued  []deleteQueueItem
+		stopped bool
+		// the tags of a docker repository are read once per run, not for every queued tag
+		tagDigests = make(map[string]dockerTagDigests)
+	)
+	for _, item := range q.Items {
+		if stopped || item.URL != feed.URL || item.Feed != feed.Feed || ctx.Err() != nil {
+			queued = append(queued, item)
+			continue
+		}
+		if chain.Type == "docker" {
+			repository, ok := tagDigests[item.Name]
+			if !ok {
+				repository.digests, repository.err = getDockerTagDigests(ctx, feed, item.Name, config.Timeout)
+				tagDigests[item.Name] = repository
+			}
+			if repository.err != nil {
+				log.Error().Err(repository.err).Str("feed", feed.Feed).Str("Action", "Delete").Msgf("Failed to check tags sharing %s:%s, will retry next iteration", item.Name, item.Version)
+				queued = append(queued, item)
+				continue
+			}
+			if kept := dockerKeptTags(repository.digests, item.Version, q.queuedVersions(feed, item.Group, item.Name)); len(kept) > 0 {
+				log.Warn().Str("feed", feed.Feed).Str("Action", "Delete").Msgf("Skip delete %s:%s: its manifest is shared with kept tags %s", item.Name, item.Version, strings.Join(kept, ", "))
+				continue
+			}
+		}
+
+		now := time.Now()
+		if !q.take(config, feed.URL, now) {
+			queued = append(queued, item)
+			stopped = true
+			continue
+		}
+
+		pkg := Package{Group: item.Group, Name: item.Name}
+		// mirror and hash mismatch deletions are not kept versions of the feed, only retention is archived
+		var record *archiveRecord
+		if config.Retention.Archive != "" && item.Kind == deleteKindRetention {
+			record, err = archiveVersion(ctx, config.Retention.Archive, driver, chain, pkg, item.Version, config.Timeout)
+			if err != nil {
+				// the version is not deleted without its archive copy
+				log.Error().Err(err).Str("feed", feed.Feed).Str("Action", "Archive").Msgf("Failed to archive %s/%s:%s, will retry next iteration", item.Group, item.Name, item.Version)
+				q.Instances[feed.URL].Used--
+				queued = append(queued, item)
+				continue
+			}
+		}
+
+		log.Warn().Str("feed", feed.Feed).Str("Action", "Retention").Msgf("Delete %s/%s:%s: %s", item.Group, item.Name, item.Version, item.Reason)
+		err, statusCode := driver.Delete(ctx, feed, pkg, item.Version, config.Timeout)
+		if err == nil && record != nil {
+			err = record.save(config.Retention.Archive)
+			if err != nil {
+				log.Error().Err(err).Str("feed", feed.Feed).Str("Action", "Archive").Msgf("Failed to write archive record of %s/%s:%s", item.Group, item.Name, item.Version)
+				err = nil
+			}
+		}
+
+		var rateLimit *rateLimitError
+		switch {
+		case err == nil:
+		case statusCode == http.StatusNotFound:
+			log.Info().Str("feed", feed.Feed).Str("Action", "Delete").Msgf("%s/%s:%s is already deleted", item.Group, item.Name, item.Version)
+		case errors.As(err, &rateLimit):
+			wait := rateLimit.RetryAfter
+			if wait <= 0 {
+				wait = time.Duration(config.DeleteQueue.Window) * time.Second
+			}
+			q.Instances[feed.URL].BlockedUntil = now.Add(wait)
+			log.Info().Str("feed", feed.Feed).Str("Action", "Delete").Msgf("Delete reqest rate limit was exeed. Next requests at %s", now.Add(wait).Format(time.RFC3339))
+			queued = append(queued, item)
+			stopped = true
+		case statusCode == http.StatusForbidden:
+			log.Info().Str("feed", feed.Feed).Str("Action", "Delete").Msgf("Add \"delete\" permission to apiKey")
+			queued = append(queued, item)
+			stopped = true
+		default:
+			log.Error().Err(err).Str("feed", feed.Feed).Str("Action", "Delete").Msgf("Failed to delete %s/%s:%s, will retry next iteration", item.Group, item.Name, item.Version)
+			queued = append(queued, item)
+		}
+	}
+	q.Items = queued
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deleteServer answers ProGet delete requests with the queued statuses, 200 once they run out.
+type deleteServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	deleted    []string
+}
+
+func newDeleteServer(t *testing.T, statuses ...int) *deleteServer {
+	server := &deleteServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		status := http.StatusOK
+		if len(server.statuses) > 0 {
+			status, server.statuses = server.statuses[0], server.statuses[1:]
+		}
+		if status == http.StatusTooManyRequests && server.retryAfter != "" {
+			w.Header().Set("Retry-After", server.retryAfter)
+		}
+		if status == http.StatusOK {
+			server.deleted = append(server.deleted, r.URL.Query().Get("version"))
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *deleteServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+func testDeleteQueue(t *testing.T, server *deleteServer, budget int) (*deleteQueue, *Config, SyncChain) {
+	path := filepath.Join(t.TempDir(), "delete-queue.json")
+	queue, err := loadDeleteQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		Timeout:     TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1},
+		DeleteQueue: DeleteQueueConfig{File: path, Budget: budget, Window: 3600},
+	}
+	chain := SyncChain{Type: "upack", Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "upack"}}
+	return queue, config, chain
+}
+
+func queueItems(chain SyncChain, versions ...string) []deleteQueueItem {
+	var items []deleteQueueItem
+	for i, version := range versions {
+		items = append(items, deleteQueueItem{
+			URL:     chain.Destination.URL,
+			Feed:    chain.Destination.Feed,
+			Group:   "group",
+			Name:    "app",
+			Version: version,
+			Reason:  "test",
+			Queued:  time.Unix(int64(i), 0),
+		})
+	}
+	return items
+}
+
+func queuedVersionList(queue *deleteQueue) []string {
+	var versions []string
+	for _, item := range queue.Items {
+		versions = append(versions, item.Version)
+	}
+	return versions
+}
+
+func TestDeleteQueueBudget(t *testing.T) {
+	server := newDeleteServer(t)
+	queue, config, chain := testDeleteQueue(t, server, 2)
+
+	err := queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "1.0.0", "2.0.0", "3.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(); len(got) != 2 || got[0] != "1.0.0" || got[1] != "2.0.0" {
+		t.Fatalf("deleted %v, want the two oldest versions", got)
+	}
+	if got := queuedVersionList(queue); len(got) != 1 || got[0] != "3.0.0" {
+		t.Fatalf("queued %v, want [3.0.0]", got)
+	}
+
+	// the budget of the window is spent, the rest waits for the next window
+	err = queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "3.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(); len(got) != 2 {
+		t.Fatalf("deleted %v after the budget was spent", got)
+	}
+	budget := queue.Instances[server.URL]
+	if want := budget.WindowStart.Add(time.Hour); !budget.BlockedUntil.Equal(want) {
+		t.Errorf("BlockedUntil = %s, want the end of the window %s", budget.BlockedUntil, want)
+	}
+
+	// the queue and the spent budget survive a restart
+	reloaded, err := loadDeleteQueue(config.DeleteQueue.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queuedVersionList(reloaded); len(got) != 1 || got[0] != "3.0.0" {
+		t.Errorf("reloaded queue %v, want [3.0.0]", got)
+	}
+	if reloaded.Instances[server.URL].Used != 2 {
+		t.Errorf("reloaded budget used %d, want 2", reloaded.Instances[server.URL].Used)
+	}
+
+	// a new window allows the remaining deletion
+	queue.Instances[server.URL].WindowStart = time.Now().Add(-2 * time.Hour)
+	queue.Instances[server.URL].BlockedUntil = time.Time{}
+	err = queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "3.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(); len(got) != 3 || len(queue.Items) != 0 {
+		t.Errorf("deleted %v with %d queued, want all three deleted", got, len(queue.Items))
+	}
+}
+
+func TestDeleteQueueRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wait       time.Duration
+	}{
+		{"seconds", "120", 120 * time.Second},
+		{"missing", "", time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newDeleteServer(t, http.StatusOK, http.StatusTooManyRequests)
+			server.retryAfter = tt.retryAfter
+			queue, config, chain := testDeleteQueue(t, server, 0)
+
+			start := time.Now()
+			err := queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "1.0.0", "2.0.0", "3.0.0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the rate limited version stays queued and nothing is sent after the 429
+			if got := queuedVersionList(queue); len(got) != 2 || got[0] != "2.0.0" || got[1] != "3.0.0" {
+				t.Fatalf("queued %v, want [2.0.0 3.0.0]", got)
+			}
+			blocked := queue.Instances[server.URL].BlockedUntil
+			if blocked.Before(start.Add(tt.wait)) || blocked.After(time.Now().Add(tt.wait)) {
+				t.Errorf("BlockedUntil = %s, want %s after the 429", blocked, tt.wait)
+			}
+
+			err = queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "2.0.0", "3.0.0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := server.requests(); len(got) != 1 {
+				t.Errorf("deleted %v while the instance asked to wait", got)
+			}
+		})
+	}
+}
+
+func TestDeleteQueueReplaceKeepsOtherKinds(t *testing.T) {
+	server := newDeleteServer(t)
+	queue, _, chain := testDeleteQueue(t, server, 0)
+	mirror := queueItems(chain, "1.0.0")
+	mirror[0].Kind = deleteKindMirror
+	queue.Items = mirror
+
+	retention := queueItems(chain, "2.0.0")
+	retention[0].Kind = deleteKindRetention
+	queue.replace(chain.Destination, deleteKindRetention, retention)
+	if got := queuedVersionList(queue); len(got) != 2 || got[0] != "1.0.0" || got[1] != "2.0.0" {
+		t.Fatalf("queued %v, want the mirror version kept", got)
+	}
+	queue.replace(chain.Destination, deleteKindRetention, nil)
+	if got := queuedVersionList(queue); len(got) != 1 || got[0] != "1.0.0" {
+		t.Errorf("queued %v, want only the mirror version", got)
+	}
+}
+
+func TestHashMismatchGoesThroughQueue(t *testing.T) {
+	server := newDeleteServer(t, http.StatusTooManyRequests)
+	queue, config, chain := testDeleteQueue(t, server, 0)
+	pkg := Package{Group: "group", Name: "app"}
+	queueDelete := func() error {
+		return queue.push(context.Background(), config, chain, deleteKindMismatch, queueItems(chain, "1.0.0")[0])
+	}
+
+	// a rate limited delete is not a success, the version stays queued
+	err := verifyPackageHash(context.Background(), chain, pkg, "1.0.0", "aa", "bb", queueDelete)
+	if err == nil {
+		t.Fatal("verifyPackageHash succeeded on a hash mismatch")
+	}
+	if got := queuedVersionList(queue); len(got) != 1 || queue.Items[0].Kind != deleteKindMismatch {
+		t.Fatalf("queued %v, want the mismatching version", got)
+	}
+
+	// queued again, it is not duplicated and waits for Retry-After
+	err = verifyPackageHash(context.Background(), chain, pkg, "1.0.0", "aa", "bb", queueDelete)
+	if err == nil || len(queue.Items) != 1 || len(server.requests()) != 0 {
+		t.Fatalf("err %v, %d queued, deleted %v", err, len(queue.Items), server.requests())
+	}
+
+	// retention replacing its own versions keeps the mismatch, the next flush deletes it
+	queue.Instances[server.URL].BlockedUntil = time.Time{}
+	err = queue.commit(context.Background(), config, chain, deleteKindRetention, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(); len(got) != 1 || len(queue.Items) != 0 {
+		t.Errorf("deleted %v with %d queued, want the mismatch deleted", got, len(queue.Items))
+	}
+
+	if err := verifyPackageHash(context.Background(), chain, pkg, "1.0.0", "aa", "aa", func() error {
+		return errors.New("deleted a matching version")
+	}); err != nil {
+		t.Errorf("verifyPackageHash on matching hashes: %v", err)
+	}
+}
+
+func TestDockerTagsReadOncePerRepository(t *testing.T) {
+	digests := map[string]string{"a": "sha256:1", "b": "sha256:1", "c": "sha256:2", "latest": "sha256:2", "d": "sha256:3"}
+	var (
+		mu      sync.Mutex
+		lists   int
+		heads   int
+		deleted []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		const manifests = "/v2/dest/app/manifests/"
+		switch {
+		case r.URL.Path == "/v2/dest/app/tags/list":
+			lists++
+			w.Write([]byte(`{"tags":["a","b","c","latest","d"]}`))
+		case r.Method == http.MethodHead && strings.HasPrefix(r.URL.Path, manifests):
+			heads++
+			w.Header().Set("Docker-Content-Digest", digests[strings.TrimPrefix(r.URL.Path, manifests)])
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, manifests))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	queue, err := loadDeleteQueue(filepath.Join(t.TempDir(), "delete-queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Timeout: TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1}}
+	chain := SyncChain{Type: "docker", Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "docker"}}
+	err = queue.commit(context.Background(), config, chain, deleteKindRetention, queueItems(chain, "a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// a and b are deleted together, c shares its manifest with the kept latest
+	if want := []string{"sha256:1", "sha256:1"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+	if lists != 1 {
+		t.Errorf("listed the tags %d times, want once", lists)
+	}
+	// every tag once for the digests, then the deleted tags for their manifests
+	if want := len(digests) + len(deleted); heads != want {
+		t.Errorf("sent %d HEAD requests, want %d", heads, want)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dockerPageSize = 1000
+
+var (
+	dockerManifestTypes = []string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}
+	dockerLinkRegexp      = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+	dockerChallengeRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+type dockerDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type dockerManifest struct {
+	MediaType string             `json:"mediaType"`
+	Config    *dockerDescriptor  `json:"config"`
+	Layers    []dockerDescriptor `json:"layers"`
+	Manifests []dockerDescriptor `json:"manifests"`
+}
+
+// dockerRepository returns the registry repository of a package, ProGet
+// serves every docker feed as a namespace of the registry.
+func dockerRepository(progetConfig ProgetConfig, name string) string {
+	return progetConfig.Feed + "/" + name
+}
+
+func dockerRegistryURL(progetConfig ProgetConfig) string {
+	return cleanURL(progetConfig.URL + "/v2")
+}
+
+// dockerDo sends a registry request with basic auth. When the registry answers
+// with a Bearer challenge, a token is requested from the realm and the request repeated.
+func dockerDo(ctx context.Context, client *http.Client, progetConfig ProgetConfig, method, URL string, headers map[string]string, body io.ReadSeeker) (*http.Response, error) {
+	send := func(authorization string) (*http.Response, error) {
+		var (
+			reader io.Reader
+			size   int64
+		)
+		if body != nil {
+			var err error
+			size, err = body.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			_, err = body.Seek(0, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+			// the client closes request bodies, the file is reused after an auth challenge
+			reader = io.NopCloser(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, URL, reader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		} else {
+			req.SetBasicAuth("api", progetConfig.APIKey)
+		}
+		resp, err := client.Do(req)
+		if resp != nil {
+			HttpRequestsTotal.With(prometheus.Labels{"action": "docker", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
+		} else {
+			HttpRequestsTotal.With(prometheus.Labels{"action": "docker", "code": "deadline", "method": req.Method}).Inc()
+		}
+		return resp, err
+	}
+
+	resp, err := send("")
+	if err != nil {
+		return nil, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	token, err := getDockerToken(ctx, client, progetConfig, challenge)
+	if err != nil {
+		return nil, err
+	}
+	return send("Bearer " + token)
+}
+
+// getDockerToken requests a token for a "Bearer realm=...,service=...,scope=..." challenge.
+func getDockerToken(ctx context.Context, client *http.Client, progetConfig ProgetConfig, challenge string) (string, error) {
+	params := make(map[string]string)
+	for _, match := range dockerChallengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("invalid auth challenge %s", challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth("api", progetConfig.APIKey)
+
+	resp, body, err := apiCall(client, req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get registry token. Status: %d", resp.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// getDockerList reads a paginated registry listing (_catalog or tags/list), following Link headers.
+func getDockerList(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, listURL, field string) ([]string, error) {
+	var items []string
+	for listURL != "" {
+		var (
+			resp *http.Response
+			body []byte
+			err  error
+		)
+		for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+			resp, err = dockerDo(ctx, client, progetConfig, "GET", listURL, nil, nil)
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if err == nil && resp.StatusCode == http.StatusOK {
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("failed to get %s. Status: %d", listURL, resp.StatusCode)
+			}
+			log.Error().Err(err).Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Attempt %d failed", attempt)
+			time.Sleep(5 * time.Duration(attempt) * time.Second)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var page map[string]json.RawMessage
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", listURL, err)
+		}
+		var pageItems []string
+		if raw, ok := page[field]; ok && string(raw) != "null" {
+			err = json.Unmarshal(raw, &pageItems)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s of %s: %w", field, listURL, err)
+			}
+		}
+		items = append(items, pageItems...)
+
+		next := ""
+		if match := dockerLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			next, err = resolveURL(listURL, match[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		listURL = next
+	}
+	return items, nil
+}
+
+// getDockerPackages lists the repositories of the feed with their tags.
+func getDockerPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting docker repositories")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	repositories, err := getDockerList(ctx, client, progetConfig, timeoutConfig, fmt.Sprintf("%s/_catalog?n=%d", dockerRegistryURL(progetConfig), dockerPageSize), "repositories")
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	prefix := progetConfig.Feed + "/"
+	for _, repository := range repositories {
+		if !strings.HasPrefix(repository, prefix) {
+			continue
+		}
+		tags, err := getDockerList(ctx, client, progetConfig, timeoutConfig, fmt.Sprintf("%s/%s/tags/list?n=%d", dockerRegistryURL(progetConfig), repository, dockerPageSize), "tags")
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		packages = append(packages, Package{
+			Group:    "",
+			Name:     strings.TrimPrefix(repository, prefix),
+			Versions: tags,
+		})
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// getDockerManifest returns the manifest body, its media type and digest.
+func getDockerManifest(ctx context.Context, client *http.Client, progetConfig ProgetConfig, repository, reference string) ([]byte, string, string, error) {
+	manifestURL := fmt.Sprintf("%s/%s/manifests/%s", dockerRegistryURL(progetConfig), repository, reference)
+	resp, err := dockerDo(ctx, client, progetConfig, "GET", manifestURL, map[string]string{"Accept": strings.Join(dockerManifestTypes, ", ")}, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("failed to get manifest %s:%s. Status: %d", repository, reference, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, "", "", fmt.Errorf("manifest %s:%s has digest %s", repository, reference, digest)
+	}
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	return body, mediaType, digest, nil
+}
+
+// getDockerDigest returns the digest of a tag without downloading the manifest.
+func getDockerDigest(ctx context.Context, client *http.Client, progetConfig ProgetConfig, repository, tag string) (string, error) {
+	manifestURL := fmt.Sprintf("%s/%s/manifests/%s", dockerRegistryURL(progetConfig), repository, tag)
+	resp, err := dockerDo(ctx, client, progetConfig, "HEAD", manifestURL, map[string]string{"Accept": strings.Join(dockerManifestTypes, ", ")}, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get manifest %s:%s. Status: %d", repository, tag, resp.StatusCode)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		_, _, digest, err = getDockerManifest(ctx, client, progetConfig, repository, tag)
+	}
+	return digest, err
+}
+
+func putDockerManifest(ctx context.Context, client *http.Client, progetConfig ProgetConfig, repository, reference, mediaType string, body []byte) error {
+	manifestURL := fmt.Sprintf("%s/%s/manifests/%s", dockerRegistryURL(progetConfig), repository, reference)
+	resp, err := dockerDo(ctx, client, progetConfig, "PUT", manifestURL, map[string]string{"Content-Type": mediaType}, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to put manifest %s:%s. Status: %d %s", repository, reference, resp.StatusCode, string(responseBody))
+	}
+	return nil
+}
+
+// copyDockerManifest copies a manifest with everything it references and
+// pushes it to the destination by digest. Returns the manifest, media type and digest.
+func copyDockerManifest(ctx context.Context, client *http.Client, timeoutConfig TimeoutConfig, chain SyncChain, srcRepository, dstRepository, reference, savePath string) ([]byte, string, string, error) {
+	body, mediaType, digest, err := getDockerManifest(ctx, client, chain.Source, srcRepository, reference)
+	if err != nil {
+		return nil, "", "", err
+	}
+	var manifest dockerManifest
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to decode manifest %s:%s: %w", srcRepository, reference, err)
+	}
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+
+	// manifest lists reference platform manifests, image manifests reference blobs
+	for _, child := range manifest.Manifests {
+		_, _, _, err = copyDockerManifest(ctx, client, timeoutConfig, chain, srcRepository, dstRepository, child.Digest, savePath)
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+	blobs := manifest.Layers
+	if manifest.Config != nil {
+		blobs = append([]dockerDescriptor{*manifest.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+			err = copyDockerBlob(ctx, client, chain, srcRepository, dstRepository, blob.Digest, savePath)
+			if err == nil {
+				break
+			}
+			log.Error().Err(err).Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Str("Action", "Upload").Msgf("Attempt: %d failed to copy blob %s", attempt, blob.Digest)
+			time.Sleep(5 * time.Duration(attempt) * time.Second)
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	err = putDockerManifest(ctx, client, chain.Destination, dstRepository, digest, mediaType, body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return body, mediaType, digest, nil
+}
+
+// copyDockerBlob uploads a blob missing on the destination. Within one
+// registry the blob is mounted from the source repository instead.
+func copyDockerBlob(ctx context.Context, client *http.Client, chain SyncChain, srcRepository, dstRepository, digest, savePath string) error {
+	dstRegistry := dockerRegistryURL(chain.Destination)
+	resp, err := dockerDo(ctx, client, chain.Destination, "HEAD", fmt.Sprintf("%s/%s/blobs/%s", dstRegistry, dstRepository, digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		log.Debug().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Blob %s exists", digest)
+		return nil
+	}
+
+	uploadURL := fmt.Sprintf("%s/%s/blobs/uploads/", dstRegistry, dstRepository)
+	if dockerRegistryURL(chain.Source) == dstRegistry {
+		uploadURL += "?" + url.Values{"mount": {digest}, "from": {srcRepository}}.Encode()
+	}
+	resp, err = dockerDo(ctx, client, chain.Destination, "POST", uploadURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Blob %s mounted from %s", digest, srcRepository)
+		return nil
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start blob upload %s. Status: %d", digest, resp.StatusCode)
+	}
+	location, err := resolveURL(uploadURL, resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(savePath, strings.ReplaceAll(digest, ":", "_"))
+	defer os.Remove(filePath)
+	err = downloadDockerBlob(ctx, client, chain.Source, srcRepository, digest, filePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	putURL, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := putURL.Query()
+	query.Set("digest", digest)
+	putURL.RawQuery = query.Encode()
+
+	log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Str("Action", "Upload").Msgf("Upload blob %s", digest)
+	resp, err = dockerDo(ctx, client, chain.Destination, "PUT", putURL.String(), map[string]string{"Content-Type": "application/octet-stream"}, file)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload blob %s. Status: %d", digest, resp.StatusCode)
+	}
+	return nil
+}
+
+// downloadDockerBlob saves a blob and checks it against its digest.
+func downloadDockerBlob(ctx context.Context, client *http.Client, progetConfig ProgetConfig, repository, digest, filePath string) error {
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Str("Action", "Download").Msgf("Download blob %s", digest)
+	resp, err := dockerDo(ctx, client, progetConfig, "GET", fmt.Sprintf("%s/%s/blobs/%s", dockerRegistryURL(progetConfig), repository, digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download blob %s. Status: %d", digest, resp.StatusCode)
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), resp.Body)
+	if err != nil {
+		return err
+	}
+	if actual := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob %s downloaded with digest %s", digest, actual)
+	}
+	return nil
+}
+
+type dockerDriver struct{}
+
+func (dockerDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getDockerPackages(ctx, feed, timeoutConfig)
+}
+
+// CompareVersions keeps the registry order, tags follow no version scheme.
+func (dockerDriver) CompareVersions(a, b string) int {
+	return 0
+}
+
+// IsPrerelease treats only SemVer tags with a prerelease label as prereleases.
+func (dockerDriver) IsPrerelease(version string) bool {
+	m := semverRegexp.FindStringSubmatch(version)
+	return m != nil && m[4] != ""
+}
+
+// Download saves the manifest the tag points to. Blobs are copied by Upload,
+// so the ones the destination already has are not downloaded.
+func (dockerDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, tag, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	repository := dockerRepository(feed, pkg.Name)
+	body, _, digest, err := getDockerManifest(ctx, client, feed, repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	filePath := filepath.Join(dir, "manifest.json")
+	err = os.WriteFile(filePath, body, 0666)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("url", feed.URL).Str("feed", feed.Feed).Str("Action", "Download").Msgf("Success download manifest %s:%s (%s)", repository, tag, digest)
+	return []transferFile{{Path: filePath, Hash: fileHash{SHA256: strings.TrimPrefix(digest, "sha256:")}}}, nil
+}
+
+// Upload copies the downloaded manifest with everything it references by digest, then tags it.
+func (dockerDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, tag string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	srcRepository := dockerRepository(chain.Source, pkg.Name)
+	dstRepository := dockerRepository(chain.Destination, pkg.Name)
+
+	log.Info().Str("url", chain.Source.URL).Str("feed", chain.Source.Feed).Msgf("Copy %s:%s", srcRepository, tag)
+	body, mediaType, _, err := copyDockerManifest(ctx, client, timeoutConfig, chain, srcRepository, dstRepository, "sha256:"+files[0].Hash.SHA256, filepath.Dir(files[0].Path))
+	if err != nil {
+		return err
+	}
+	return putDockerManifest(ctx, client, chain.Destination, dstRepository, tag, mediaType, body)
+}
+
+// Hash compares the manifest digests of the tag.
+func (dockerDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, tag string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	DestHash, err := getDockerDigest(ctx, client, chain.Destination, dockerRepository(chain.Destination, pkg.Name), tag)
+	if err != nil {
+		return "", "", err
+	}
+	return "sha256:" + files[0].Hash.SHA256, DestHash, nil
+}
+
+// FeedHash reads the manifest digest of the tag.
+func (dockerDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, tag string, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	digest, err := getDockerDigest(ctx, client, feed, dockerRepository(feed, pkg.Name), tag)
+	return "digest", digest, err
+}
+
+func (dockerDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, tag string, timeoutConfig TimeoutConfig) (error, int) {
+	return deleteDockerTag(ctx, feed, pkg.Name, tag, timeoutConfig)
+}
+
+// getDockerTagDigests returns the manifest digest of every tag of the repository.
+func getDockerTagDigests(ctx context.Context, progetConfig ProgetConfig, name string, timeoutConfig TimeoutConfig) (map[string]string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	repository := dockerRepository(progetConfig, name)
+	tags, err := getDockerList(ctx, client, progetConfig, timeoutConfig, fmt.Sprintf("%s/%s/tags/list?n=%d", dockerRegistryURL(progetConfig), repository, dockerPageSize), "tags")
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		digests[tag], err = getDockerDigest(ctx, client, progetConfig, repository, tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digests, nil
+}
+
+// dockerKeptTags returns the other tags of digests pointing to the manifest
+// of tag that are not in deleted. Deleting the manifest deletes them as well,
+// so the tag is deleted only when none is returned.
+func dockerKeptTags(digests map[string]string, tag string, deleted map[string]bool) []string {
+	digest, ok := digests[tag]
+	if !ok {
+		return nil
+	}
+	var kept []string
+	for other, otherDigest := range digests {
+		if other != tag && !deleted[other] && otherDigest == digest {
+			kept = append(kept, other)
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// deleteDockerTag deletes the manifest a tag points to. The registry API
+// deletes by digest, so other tags of the same manifest are removed as well,
+// the delete queue checks them with dockerKeptTags first.
+func deleteDockerTag(ctx context.Context, progetConfig ProgetConfig, name, tag string, timeoutConfig TimeoutConfig) (error, int) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	repository := dockerRepository(progetConfig, name)
+	digest, err := getDockerDigest(ctx, client, progetConfig, repository, tag)
+	if err != nil {
+		return err, 0
+	}
+
+	resp, err := dockerDo(ctx, client, progetConfig, "DELETE", fmt.Sprintf("%s/%s/manifests/%s", dockerRegistryURL(progetConfig), repository, digest), nil, nil)
+	if err != nil {
+		return err, 0
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return newRateLimitError(resp, fmt.Errorf("failed to delete %s:%s, rate limit exceeded", repository, tag)), resp.StatusCode
+	}
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete %s:%s. Status: %d", repository, tag, resp.StatusCode), resp.StatusCode
+	}
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Str("Action", "Delete").Msgf("Success delete: %s:%s (%s)", repository, tag, digest)
+	return nil, resp.StatusCode
+}
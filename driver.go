@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FeedDriver implements the operations of one feed type. A version is synced
+// by Download into a temporary directory, Upload of the downloaded files to
+// the destination and a comparison of the two hashes Hash returns.
+type FeedDriver interface {
+	// List returns the packages of the feed with their versions in any order.
+	List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error)
+	// CompareVersions returns a negative number when version a is older than b,
+	// a positive one when it is newer and 0 when their order is unknown.
+	CompareVersions(a, b string) int
+	// IsPrerelease reports whether the version is a prerelease in the scheme of the feed.
+	IsPrerelease(version string) bool
+	// Download saves the files of a version from the source feed into dir.
+	Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error)
+	// Upload pushes downloaded files to the destination of the chain.
+	Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error
+	// Hash returns the hash of the downloaded version and the one the destination reports.
+	Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error)
+	// Delete removes a version from the feed.
+	Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int)
+}
+
+// publishDater is implemented by drivers whose feeds report when a version was
+// published, retention keeps versions by age only for these types.
+type publishDater interface {
+	// PublishDates returns the publish time of the versions of a package, versions without one are omitted.
+	PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error)
+}
+
+// feedHasher is implemented by drivers whose feeds report the hash of a
+// version, fan-in compares the sources of a version by it without downloading.
+type feedHasher interface {
+	// FeedHash returns the algorithm and the hex hash the feed reports for the version, an empty hash when it reports none.
+	FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error)
+}
+
+// versionNormalizer is implemented by drivers whose feeds list the same version
+// in different forms, versions of two feeds are matched by the normalized one.
+type versionNormalizer interface {
+	// NormalizeVersion returns the form versions are matched by.
+	NormalizeVersion(version string) string
+}
+
+// versionKey returns the version the way it is matched between feeds.
+func versionKey(driver FeedDriver, version string) string {
+	if normalizer, ok := driver.(versionNormalizer); ok {
+		return normalizer.NormalizeVersion(version)
+	}
+	return version
+}
+
+// transferFile is a file downloaded from the source feed.
+type transferFile struct {
+	Path string
+	Hash fileHash
+}
+
+var feedDrivers = map[string]FeedDriver{
+	"upack":  upackDriver{},
+	"asset":  assetDriver{},
+	"nuget":  nugetDriver{},
+	"npm":    npmDriver{},
+	"maven":  mavenDriver{},
+	"pypi":   pypiDriver{},
+	"docker": dockerDriver{},
+	"helm":   helmDriver{},
+	"debian": debianDriver{},
+	"rpm":    rpmDriver{},
+}
+
+func getFeedDriver(feedType string) (FeedDriver, error) {
+	driver, ok := feedDrivers[feedType]
+	if !ok {
+		return nil, fmt.Errorf("unknown feed type %q", feedType)
+	}
+	return driver, nil
+}
+
+// feedTypes returns the registered feed types for messages.
+func feedTypes() []string {
+	types := make([]string, 0, len(feedDrivers))
+	for feedType := range feedDrivers {
+		types = append(types, feedType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// createTransferDir creates the directory a version is downloaded into. It is
+// unique per transfer, as chains running in parallel may sync the same version.
+func createTransferDir(savePath string, pkg Package, version string) (string, error) {
+	err := os.MkdirAll(savePath, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create dir %s: %w", savePath, err)
+	}
+	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+	dir, err := os.MkdirTemp(savePath, replacer.Replace(fmt.Sprintf("%s.%s.%s.", pkg.Group, pkg.Name, version)))
+	if err != nil {
+		return "", fmt.Errorf("failed to create dir in %s: %w", savePath, err)
+	}
+	return dir, nil
+}
+
+// downloadWithRetries downloads URL into filePath. check validates the
+// downloaded file and may be nil, a failed check is retried like a failed download.
+func downloadWithRetries(ctx context.Context, URL, filePath string, feed ProgetConfig, timeoutConfig TimeoutConfig, check func(fileHash) error) (fileHash, error) {
+	for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+		log.Info().Str("url", feed.URL).Str("feed", feed.Feed).Str("Action", "Download").Msgf("Attempt %d download file %s", attempt, filepath.Base(filePath))
+		hash, err, statusCode := downloadFile(ctx, URL, filePath, feed, timeoutConfig)
+		if statusCode == 401 {
+			return fileHash{}, fmt.Errorf("failed to download %s, check apiKey permisson (Download)", filepath.Base(filePath))
+		}
+		if err == nil && check != nil {
+			err = check(hash)
+		}
+		if err == nil {
+			return hash, nil
+		}
+		log.Error().Err(err).Str("url", feed.URL).Str("feed", feed.Feed).Str("Action", "Download").Msgf("Attempt: %d failed", attempt)
+		time.Sleep(5 * time.Duration(attempt) * time.Second)
+	}
+	return fileHash{}, fmt.Errorf("failed to download %s", filepath.Base(filePath))
+}
+
+// uploadWithRetries uploads filePath to URL with the request newRequest builds.
+func uploadWithRetries(ctx context.Context, URL, filePath string, feed ProgetConfig, timeoutConfig TimeoutConfig, newRequest uploadRequest) error {
+	for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+		log.Info().Str("url", feed.URL).Str("feed", feed.Feed).Str("Action", "Upload").Msgf("Attempt %d upload file %s", attempt, filepath.Base(filePath))
+		err, statusCode := uploadFile(ctx, URL, filePath, feed, timeoutConfig, newRequest)
+		if statusCode == 401 {
+			return fmt.Errorf("failed to upload %s, check apiKey permisson (add)", filepath.Base(filePath))
+		}
+		if err == nil {
+			return nil
+		}
+		log.Error().Err(err).Str("url", feed.URL).Str("feed", feed.Feed).Str("Action", "Upload").Msgf("Attempt: %d failed", attempt)
+		time.Sleep(5 * time.Duration(attempt) * time.Second)
+	}
+	return fmt.Errorf("failed to upload %s", filepath.Base(filePath))
+}
+
+// packagesDeleteURL returns the ProGet packages API endpoint deleting the version query identifies.
+func packagesDeleteURL(feed ProgetConfig, query url.Values) string {
+	return cleanURL(fmt.Sprintf("%s/api/packages/%s/delete?%s", feed.URL, feed.Feed, query.Encode()))
+}
+
+// deletePackage deletes a version through the ProGet packages API.
+func deletePackage(ctx context.Context, feed ProgetConfig, pkg Package, version string, query url.Values, timeoutConfig TimeoutConfig) (error, int) {
+	return deleteFile(ctx, packagesDeleteURL(feed, query), feed.APIKey, feed.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+}
+
+// putRequest sends the file as the request body, the way most ProGet feeds accept uploads.
+func putRequest(ctx context.Context, URL string, file *os.File, feed ProgetConfig) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, "PUT", URL, file)
+}
+
+// parsePublishTime reads the timestamps feeds publish: RFC 3339, or without
+// a zone like NuGet OData, which is UTC.
+func parsePublishTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
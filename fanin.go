@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// versionSources maps "group:name:version" to the sources listing the version
+// in precedence order. It is nil for chains with a single source.
+type versionSources map[string][]ProgetConfig
+
+// getSourcePackages lists every source of the chain and merges the listings.
+// A version is taken from the first source in sources that has it. The chain
+// is skipped when a source cannot be listed, as precedence and mirror deletions
+// depend on the complete listing.
+func getSourcePackages(ctx context.Context, config *Config, chain SyncChain) ([]Package, versionSources, error) {
+	if len(chain.Sources) <= 1 {
+		packages, err := getPackages(ctx, chain.Source, config.Timeout)
+		return packages, nil, err
+	}
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var merged []Package
+	index := make(map[string]int)
+	sources := make(versionSources)
+	for _, source := range chain.Sources {
+		packages, err := getPackages(ctx, source, config.Timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get packages from %s/%s: %w", source.URL, source.Feed, err)
+		}
+		for _, pkg := range packages {
+			key := fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)
+			i, exists := index[key]
+			if !exists {
+				i = len(merged)
+				index[key] = i
+				merged = append(merged, Package{Group: pkg.Group, Name: pkg.Name})
+			}
+			for _, version := range pkg.Versions {
+				versionKey := fmt.Sprintf("%s:%s", key, version)
+				if len(sources[versionKey]) == 0 {
+					merged[i].Versions = append(merged[i].Versions, version)
+				}
+				sources[versionKey] = append(sources[versionKey], source)
+			}
+		}
+	}
+	sortVersions(driver, merged)
+	log.Info().Str("feed", chain.Destination.Feed).Msgf("Merged %d packages from %d sources", len(merged), len(chain.Sources))
+	return merged, sources, nil
+}
+
+// chainFor returns the chain with the source the version is taken from.
+func (s versionSources) chainFor(chain SyncChain, pkg Package, version string) SyncChain {
+	found := s[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)]
+	if len(found) == 0 {
+		return chain
+	}
+	single := chain
+	single.Source = found[0]
+	return single
+}
+
+// sourceConflicts remembers the versions found to differ between the sources
+// of a chain by "sources|group:name:version". A conflict is skipped without
+// comparing the sources again until recheckIterations iterations pass.
+var (
+	sourceConflicts   = make(map[string]sourceConflict)
+	sourceConflictsMu sync.Mutex
+)
+
+type sourceConflict struct {
+	err       *sourceConflictError
+	iteration int
+}
+
+func sourceConflictKey(found []ProgetConfig, pkg Package, version string) string {
+	var key strings.Builder
+	for _, source := range found {
+		key.WriteString(source.URL + "/" + source.Feed + "|")
+	}
+	key.WriteString(fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version))
+	return key.String()
+}
+
+// download saves the version from the source it is taken from into dir. A
+// version that differs between sources is logged as a conflict and returned as
+// a *sourceConflictError, so it fails instead of syncing the content of one source.
+func (s versionSources) download(ctx context.Context, config *Config, chain SyncChain, driver FeedDriver, pkg Package, version, dir string) ([]transferFile, error) {
+	chain = s.chainFor(chain, pkg, version)
+	found := s[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)]
+	key := sourceConflictKey(found, pkg, version)
+	sourceConflictsMu.Lock()
+	known, ok := sourceConflicts[key]
+	sourceConflictsMu.Unlock()
+	if ok && syncIteration-known.iteration < recheckIterations {
+		log.Error().Str("feed", chain.Destination.Feed).Str("Action", "Conflict").Msgf("%s/%s:%s skipped: %s", pkg.Group, pkg.Name, version, known.err)
+		return nil, known.err
+	}
+
+	unhashed, err := s.checkReportedHashes(ctx, config, chain, driver, pkg, version)
+	var files []transferFile
+	if err == nil {
+		files, err = driver.Download(ctx, chain.Source, pkg, version, dir, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		err = s.checkSources(ctx, config, chain, driver, pkg, version, dir, files, unhashed)
+	}
+	var conflict *sourceConflictError
+	if errors.As(err, &conflict) {
+		sourceConflictsMu.Lock()
+		sourceConflicts[key] = sourceConflict{err: conflict, iteration: syncIteration}
+		sourceConflictsMu.Unlock()
+		log.Error().Str("feed", chain.Destination.Feed).Str("Action", "Conflict").Msgf("%s/%s:%s skipped: %s", pkg.Group, pkg.Name, version, conflict)
+		return nil, conflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	sourceConflictsMu.Lock()
+	delete(sourceConflicts, key)
+	sourceConflictsMu.Unlock()
+	return files, nil
+}
+
+// checkReportedHashes compares the hash the source of the chain reports for
+// the version with the ones of the other sources listing it, for drivers whose
+// feeds report hashes. It returns the other sources that reported none, or
+// another algorithm, to be compared by downloading the version.
+func (s versionSources) checkReportedHashes(ctx context.Context, config *Config, chain SyncChain, driver FeedDriver, pkg Package, version string) ([]ProgetConfig, error) {
+	found := s[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)]
+	if len(found) <= 1 {
+		return nil, nil
+	}
+	hasher, ok := driver.(feedHasher)
+	if !ok {
+		return found[1:], nil
+	}
+	algorithm, hash, err := hasher.FeedHash(ctx, found[0], pkg, version, config.Timeout)
+	if err != nil || hash == "" {
+		if err != nil {
+			log.Warn().Err(err).Str("url", found[0].URL).Str("feed", found[0].Feed).Msgf("Failed to get hash of %s/%s:%s, will compare downloads", pkg.Group, pkg.Name, version)
+		}
+		return found[1:], nil
+	}
+
+	var unhashed []ProgetConfig
+	for _, source := range found[1:] {
+		otherAlgorithm, otherHash, err := hasher.FeedHash(ctx, source, pkg, version, config.Timeout)
+		if err != nil {
+			log.Warn().Err(err).Str("url", source.URL).Str("feed", source.Feed).Msgf("Failed to get hash of %s/%s:%s, will compare downloads", pkg.Group, pkg.Name, version)
+		}
+		if err != nil || otherHash == "" || otherAlgorithm != algorithm {
+			unhashed = append(unhashed, source)
+			continue
+		}
+		if otherHash != hash {
+			return nil, &sourceConflictError{Version: version, First: chain.Source, Second: source}
+		}
+	}
+	return unhashed, nil
+}
+
+// checkSources downloads the version from the other sources and compares the
+// files with the ones downloaded from the source of the chain. A version that
+// differs between sources is a conflict and must not be synced.
+func (s versionSources) checkSources(ctx context.Context, config *Config, chain SyncChain, driver FeedDriver, pkg Package, version, dir string, files []transferFile, others []ProgetConfig) error {
+	for i, source := range others {
+		otherDir := fmt.Sprintf("%s.source%d", dir, i+1)
+		err := os.MkdirAll(otherDir, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create dir %s: %w", otherDir, err)
+		}
+		other, err := driver.Download(ctx, source, pkg, version, otherDir, config.Timeout)
+		os.RemoveAll(otherDir)
+		if err != nil {
+			return fmt.Errorf("failed to download from %s/%s to compare sources: %w", source.URL, source.Feed, err)
+		}
+		if !sameTransferFiles(dir, files, otherDir, other) {
+			return &sourceConflictError{Version: version, First: chain.Source, Second: source}
+		}
+	}
+	return nil
+}
+
+// sameTransferFiles compares the names and SHA-1 of two downloads of a version.
+func sameTransferFiles(dir string, files []transferFile, otherDir string, other []transferFile) bool {
+	if len(files) != len(other) {
+		return false
+	}
+	for i := range files {
+		name, _ := filepath.Rel(dir, files[i].Path)
+		otherName, _ := filepath.Rel(otherDir, other[i].Path)
+		if name != otherName || files[i].Hash.SHA1 != other[i].Hash.SHA1 {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceConflictError is returned for versions the sources of a chain publish with different content.
+type sourceConflictError struct {
+	Version string
+	First   ProgetConfig
+	Second  ProgetConfig
+}
+
+func (e *sourceConflictError) Error() string {
+	return fmt.Sprintf("version %s differs on %s/%s and %s/%s", e.Version, e.First.URL, e.First.Feed, e.Second.URL, e.Second.Feed)
+}
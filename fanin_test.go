@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSourceConflictFailsTransfer(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/versions"):
+			// the feeds report different hashes for the version
+			fmt.Fprintf(w, `{"sha1":"%x"}`, r.URL.Path)
+		case strings.Contains(r.URL.Path, "/download/"):
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write([]byte("package"))
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	first := ProgetConfig{URL: server.URL, Feed: "first", Type: "upack"}
+	second := ProgetConfig{URL: server.URL, Feed: "second", Type: "upack"}
+	chain := SyncChain{Type: "upack", Source: first, Sources: []ProgetConfig{first, second}, Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "upack"}}
+	config := &Config{Timeout: TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1}}
+	pkg := Package{Group: "g", Name: "app"}
+	sources := versionSources{"g:app:1.0.0": {first, second}}
+
+	err := downloadAndUploadPackage(context.Background(), config, chain, nil, sources, pkg, "1.0.0", t.TempDir())
+	var conflict *sourceConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("downloadAndUploadPackage returned %v, want the source conflict", err)
+	}
+	mu.Lock()
+	for _, request := range requests {
+		if !strings.HasSuffix(request, "/versions") {
+			t.Errorf("sent %s for a conflicting version", request)
+		}
+	}
+	requests = nil
+	mu.Unlock()
+
+	// the remembered conflict fails again without comparing the sources
+	err = downloadAndUploadPackage(context.Background(), config, chain, nil, sources, pkg, "1.0.0", t.TempDir())
+	if !errors.As(err, &conflict) {
+		t.Fatalf("downloadAndUploadPackage returned %v for a known conflict", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 0 {
+		t.Errorf("sent %v for a known conflict", requests)
+	}
+}
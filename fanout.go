@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fanOutTarget is one destination of a chain with the versions it lacks.
+type fanOutTarget struct {
+	chain        SyncChain
+	destPackages []Package
+	syncPackages []Package
+}
+
+// withDestination returns the chain with a single destination, the form the
+// drivers, retention and the delete queue work with.
+func (chain SyncChain) withDestination(destination ProgetConfig) SyncChain {
+	single := chain
+	single.Destination = destination
+	single.Destinations = []ProgetConfig{destination}
+	return single
+}
+
+// transferFanOut downloads every version some destination lacks once and
+// uploads it to each of these destinations. The package and version limits
+// apply to the versions downloaded, a failed destination does not stop the others.
+func transferFanOut(ctx context.Context, config *Config, chain SyncChain, pool *transferPool, queue *deleteQueue, sourcePackages []Package, sources versionSources, targets []fanOutTarget) error {
+	lacking := make(map[string][]fanOutTarget)
+	for _, target := range targets {
+		for _, pkg := range target.syncPackages {
+			for _, version := range pkg.Versions {
+				key := fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)
+				lacking[key] = append(lacking[key], target)
+			}
+		}
+	}
+
+	var syncPackages []Package
+	for _, pkg := range sourcePackages {
+		var versions []string
+		for _, version := range pkg.Versions {
+			if len(lacking[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)]) > 0 {
+				versions = append(versions, version)
+			}
+		}
+		if len(versions) > 0 {
+			syncPackages = append(syncPackages, Package{Group: pkg.Group, Name: pkg.Name, Versions: versions})
+		}
+	}
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+	sortPackagesToSync(config, driver, syncPackages)
+	syncPackages = limitPackages(config, syncPackages)
+
+	log.Info().Msgf("Will sync %d packages to %d destinations", len(syncPackages), len(targets))
+
+	group := pool.group(config.Concurrency.PerChain)
+	for _, pkg := range syncPackages {
+		for _, version := range pkg.Versions {
+			pkg, version := pkg, version
+			versionTargets := lacking[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, version)]
+			feeds := []ProgetConfig{sources.chainFor(chain, pkg, version).Source}
+			for _, target := range versionTargets {
+				feeds = append(feeds, target.chain.Destination)
+			}
+			err := group.submit(ctx, feeds, func(ctx context.Context) error {
+				err := fanOutVersion(ctx, config, chain, queue, sources, pkg, version, versionTargets)
+				if err != nil {
+					return fmt.Errorf("failed to sync package %s/%s:%s, error: %w", pkg.Group, pkg.Name, version, err)
+				}
+				return nil
+			})
+			if err != nil {
+				group.wait()
+				return err
+			}
+		}
+	}
+	return group.wait()
+}
+
+// fanOutVersion downloads a version from the source into savePath and uploads
+// it to the targets. Uploads remove the uploaded files, so every target but
+// the last one gets a copy of the download.
+func fanOutVersion(ctx context.Context, config *Config, chain SyncChain, queue *deleteQueue, sources versionSources, pkg Package, version string, targets []fanOutTarget) error {
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+
+	dir, err := createTransferDir(*savePath, pkg, version)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := sources.download(ctx, config, chain, driver, pkg, version, dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, target := range targets {
+		uploadFiles := files
+		if i < len(targets)-1 {
+			copyPath := fmt.Sprintf("%s.%d", dir, i)
+			uploadFiles, err = copyTransferFiles(dir, copyPath, files)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s/%s: %w", target.chain.Destination.URL, target.chain.Destination.Feed, err))
+				continue
+			}
+		}
+		err = uploadAndVerify(ctx, config, sources.chainFor(target.chain, pkg, version), queue, driver, pkg, version, uploadFiles)
+		if i < len(targets)-1 {
+			os.RemoveAll(fmt.Sprintf("%s.%d", dir, i))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("url", target.chain.Destination.URL).Str("feed", target.chain.Destination.Feed).Msgf("Failed to sync %s/%s:%s", pkg.Group, pkg.Name, version)
+			errs = append(errs, fmt.Errorf("%s/%s: %w", target.chain.Destination.URL, target.chain.Destination.Feed, err))
+			continue
+		}
+		log.Info().Str("url", target.chain.Destination.URL).Str("feed", target.chain.Destination.Feed).Msgf("Synced %s/%s:%s", pkg.Group, pkg.Name, version)
+	}
+	return errors.Join(errs...)
+}
+
+// copyTransferFiles copies the download directory and returns the files with paths in the copy.
+func copyTransferFiles(dir, copyPath string, files []transferFile) ([]transferFile, error) {
+	err := copyDir(dir, copyPath)
+	if err != nil {
+		return nil, err
+	}
+	copied := make([]transferFile, len(files))
+	for i, file := range files {
+		rel, err := filepath.Rel(dir, file.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("downloaded file %s is outside of %s", file.Path, dir)
+		}
+		copied[i] = transferFile{Path: filepath.Join(copyPath, rel), Hash: file.Hash}
+	}
+	return copied, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// versionRange is a SemVer style range: comparator sets separated by "||",
+// a version is in the range when it satisfies every comparator of one set.
+type versionRange [][]versionComparator
+
+type versionComparator struct {
+	Operator string
+	Version  string
+}
+
+// parseVersionRange parses expressions like ">=2.0.0 <3 || =1.5.2". A
+// version without an operator must be equal.
+func parseVersionRange(expression string) (versionRange, error) {
+	var result versionRange
+	for _, set := range strings.Split(expression, "||") {
+		var comparators []versionComparator
+		for _, field := range strings.Fields(set) {
+			comparator := versionComparator{Operator: "=", Version: field}
+			for _, operator := range []string{">=", "<=", ">", "<", "="} {
+				if strings.HasPrefix(field, operator) {
+					comparator = versionComparator{Operator: operator, Version: strings.TrimPrefix(field, operator)}
+					break
+				}
+			}
+			if comparator.Version == "" {
+				return nil, fmt.Errorf("comparator %q has no version", field)
+			}
+			comparators = append(comparators, comparator)
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("empty comparator set in %q", expression)
+		}
+		result = append(result, comparators)
+	}
+	return result, nil
+}
+
+// contains checks the version with the order of the feed type, an empty range contains every version.
+func (r versionRange) contains(driver FeedDriver, version string) bool {
+	if len(r) == 0 {
+		return true
+	}
+	for _, set := range r {
+		satisfied := true
+		for _, comparator := range set {
+			result := driver.CompareVersions(version, comparator.Version)
+			switch comparator.Operator {
+			case ">=":
+				satisfied = result >= 0
+			case "<=":
+				satisfied = result <= 0
+			case ">":
+				satisfied = result > 0
+			case "<":
+				satisfied = result < 0
+			default:
+				satisfied = result == 0
+			}
+			if !satisfied {
+				break
+			}
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+// compile compiles the expressions of the filters once, matches uses them for every package.
+func (f FilterConfig) compile() {
+	for _, filters := range [][]PackageFilter{f.Include, f.Exclude} {
+		for i := range filters {
+			// the expressions are checked by validateConfig
+			if filters[i].GroupRegex != "" {
+				filters[i].groupRegexp = regexp.MustCompile(filters[i].GroupRegex)
+			}
+			if filters[i].NameRegex != "" {
+				filters[i].nameRegexp = regexp.MustCompile(filters[i].NameRegex)
+			}
+		}
+	}
+}
+
+func (f PackageFilter) matches(pkg Package) bool {
+	if !matchPattern(f.Group, pkg.Group) || !matchPattern(f.Name, pkg.Name) {
+		return false
+	}
+	if f.groupRegexp != nil && !f.groupRegexp.MatchString(pkg.Group) {
+		return false
+	}
+	if f.nameRegexp != nil && !f.nameRegexp.MatchString(pkg.Name) {
+		return false
+	}
+	return true
+}
+
+func (f FilterConfig) keepPackage(pkg Package) bool {
+	included := len(f.Include) == 0
+	for _, include := range f.Include {
+		if include.matches(pkg) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, exclude := range f.Exclude {
+		if exclude.matches(pkg) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterPackages returns the packages and versions the filter keeps with the
+// numbers of filtered out packages and versions of kept packages.
+func filterPackages(filter FilterConfig, driver FeedDriver, packages []Package) ([]Package, int, int) {
+	var versions versionRange
+	if filter.Versions != "" {
+		// the range is checked by validateConfig
+		versions, _ = parseVersionRange(filter.Versions)
+	}
+
+	var (
+		kept             []Package
+		filteredPackages int
+		filteredVersions int
+	)
+	for _, pkg := range packages {
+		if !filter.keepPackage(pkg) {
+			filteredPackages++
+			continue
+		}
+		keptPackage := Package{Group: pkg.Group, Name: pkg.Name}
+		for _, version := range pkg.Versions {
+			if !versions.contains(driver, version) || (filter.ExcludePrerelease && driver.IsPrerelease(version)) {
+				filteredVersions++
+				continue
+			}
+			keptPackage.Versions = append(keptPackage.Versions, version)
+		}
+		kept = append(kept, keptPackage)
+	}
+	return kept, filteredPackages, filteredVersions
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		driver     FeedDriver
+		expression string
+		version    string
+		want       bool
+	}{
+		{npmDriver{}, ">=2.0.0 <3", "2.0.0", true},
+		{npmDriver{}, ">=2.0.0 <3", "2.10.1", true},
+		{npmDriver{}, ">=2.0.0 <3", "3.0.0", false},
+		{npmDriver{}, ">=2.0.0 <3", "1.9.9", false},
+		{npmDriver{}, ">=2.0.0 <3 || =1.5.2", "1.5.2", true},
+		{npmDriver{}, ">=2.0.0 <3 || =1.5.2", "1.5.3", false},
+		{npmDriver{}, "1.5.2", "1.5.2", true},
+		{npmDriver{}, ">1.0.0", "1.0.0", false},
+		{npmDriver{}, "<=1.0.0", "1.0.0", true},
+		// the order of the feed type is used, a prerelease is before its release
+		{npmDriver{}, "<2.0.0", "2.0.0-rc.1", true},
+		{mavenDriver{}, "<1.0", "1.0-SNAPSHOT", true},
+		{mavenDriver{}, ">1.0", "1.0-sp1", true},
+		{debianDriver{}, ">=1.0", "1.0~rc1", false},
+		{nugetDriver{}, "=1.0", "1.0.0.0", true},
+	}
+	for _, tt := range tests {
+		versions, err := parseVersionRange(tt.expression)
+		if err != nil {
+			t.Fatalf("parseVersionRange(%q): %v", tt.expression, err)
+		}
+		if got := versions.contains(tt.driver, tt.version); got != tt.want {
+			t.Errorf("%T: %q contains %q = %v, want %v", tt.driver, tt.expression, tt.version, got, tt.want)
+		}
+	}
+
+	if !(versionRange(nil)).contains(npmDriver{}, "0.0.1") {
+		t.Error("an empty range does not contain a version")
+	}
+}
+
+func TestParseVersionRangeErrors(t *testing.T) {
+	for _, expression := range []string{">=", "1.0 || ", ">=1.0 || <"} {
+		if _, err := parseVersionRange(expression); err == nil {
+			t.Errorf("parseVersionRange(%q) succeeded", expression)
+		}
+	}
+}
+
+func TestFilterPackages(t *testing.T) {
+	packages := []Package{
+		{Group: "tools", Name: "cli", Versions: []string{"3.0.0", "2.1.0", "2.0.0-rc.1", "1.0.0"}},
+		{Group: "tools", Name: "cli-test", Versions: []string{"2.0.0"}},
+		{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+		{Group: "internal", Name: "cli", Versions: []string{"2.0.0"}},
+	}
+	filter := FilterConfig{
+		Include:           []PackageFilter{{Group: "tools"}, {NameRegex: "^core$"}},
+		Exclude:           []PackageFilter{{Name: "*-test"}},
+		Versions:          ">=2.0.0 <3",
+		ExcludePrerelease: true,
+	}
+	filter.compile()
+
+	kept, filteredPackages, filteredVersions := filterPackages(filter, npmDriver{}, packages)
+	want := []Package{
+		{Group: "tools", Name: "cli", Versions: []string{"2.1.0"}},
+		{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	// cli-test is excluded, internal/cli is not included
+	if filteredPackages != 2 {
+		t.Errorf("filtered packages %d, want 2", filteredPackages)
+	}
+	// 3.0.0 and 1.0.0 are out of the range, 2.0.0-rc.1 is a prerelease
+	if filteredVersions != 3 {
+		t.Errorf("filtered versions %d, want 3", filteredVersions)
+	}
+}
+
+func TestFilterPackagesEmpty(t *testing.T) {
+	packages := []Package{{Group: "g", Name: "a", Versions: []string{"1.0.0-rc.1", "1.0.0"}}}
+	kept, filteredPackages, filteredVersions := filterPackages(FilterConfig{}, npmDriver{}, packages)
+	if !reflect.DeepEqual(kept, packages) || filteredPackages != 0 || filteredVersions != 0 {
+		t.Errorf("empty filter kept %v, filtered %d packages and %d versions", kept, filteredPackages, filteredVersions)
+	}
+}
+
+func TestPackageFilterMatches(t *testing.T) {
+	tests := []struct {
+		filter PackageFilter
+		pkg    Package
+		want   bool
+	}{
+		{PackageFilter{}, Package{Group: "g", Name: "a"}, true},
+		{PackageFilter{Group: "tools/*"}, Package{Group: "tools/build", Name: "a"}, true},
+		{PackageFilter{Group: "tools/*"}, Package{Group: "tools/build/x", Name: "a"}, false},
+		{PackageFilter{Name: "lib?"}, Package{Name: "lib1"}, true},
+		{PackageFilter{Name: "lib?"}, Package{Name: "lib10"}, false},
+		{PackageFilter{GroupRegex: "^(a|b)$", Name: "x*"}, Package{Group: "b", Name: "xy"}, true},
+		{PackageFilter{GroupRegex: "^(a|b)$", Name: "x*"}, Package{Group: "c", Name: "xy"}, false},
+		{PackageFilter{NameRegex: "core"}, Package{Name: "libcore2"}, true},
+	}
+	for _, tt := range tests {
+		filters := []PackageFilter{tt.filter}
+		FilterConfig{Include: filters}.compile()
+		if got := filters[0].matches(tt.pkg); got != tt.want {
+			t.Errorf("%+v matches %+v = %v, want %v", tt.filter, tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		filter    FilterConfig
+		chainType string
+		errors    int
+	}{
+		{FilterConfig{Include: []PackageFilter{{Group: "a*", NameRegex: "^b"}}, Versions: ">=1.0"}, "npm", 0},
+		{FilterConfig{Include: []PackageFilter{{Group: "[a"}}}, "npm", 1},
+		{FilterConfig{Exclude: []PackageFilter{{NameRegex: "("}}}, "npm", 1},
+		{FilterConfig{Versions: ">="}, "npm", 1},
+		{FilterConfig{Versions: ">=1.0"}, "docker", 1},
+		{FilterConfig{Versions: ">=1.0"}, "asset", 1},
+	}
+	for _, tt := range tests {
+		if got := validateFilter(tt.filter, tt.chainType, "filter"); len(got) != tt.errors {
+			t.Errorf("validateFilter(%+v, %s) = %v, want %d errors", tt.filter, tt.chainType, got, tt.errors)
+		}
+	}
+}
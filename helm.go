@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type helmIndex struct {
+	Entries map[string][]helmChartVersion `yaml:"entries"`
+}
+
+type helmChartVersion struct {
+	Version string   `yaml:"version"`
+	Digest  string   `yaml:"digest"`
+	URLs    []string `yaml:"urls"`
+	Created string   `yaml:"created"`
+}
+
+// helmIndexCache keeps index.yaml the last listing of every feed parsed, so
+// downloads, hash checks and publish dates do not fetch it for every version.
+var (
+	helmIndexCache   = make(map[string]*helmIndex)
+	helmIndexCacheMu sync.Mutex
+)
+
+func helmIndexURL(progetConfig ProgetConfig) string {
+	return cleanURL(fmt.Sprintf("%s/helm/%s/index.yaml", progetConfig.URL, progetConfig.Feed))
+}
+
+func getHelmIndex(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) (*helmIndex, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+	body, err, _ := getBody(ctx, client, helmIndexURL(progetConfig), progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	var index helmIndex
+	err = yaml.Unmarshal(body, &index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", helmIndexURL(progetConfig), err)
+	}
+	helmIndexCacheMu.Lock()
+	helmIndexCache[repoIndexKey(progetConfig)] = &index
+	helmIndexCacheMu.Unlock()
+	return &index, nil
+}
+
+// cachedHelmIndex returns index.yaml of the last listing of the feed, reading it when the feed was not listed.
+func cachedHelmIndex(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) (*helmIndex, error) {
+	helmIndexCacheMu.Lock()
+	index := helmIndexCache[repoIndexKey(progetConfig)]
+	helmIndexCacheMu.Unlock()
+	if index != nil {
+		return index, nil
+	}
+	return getHelmIndex(ctx, progetConfig, timeoutConfig)
+}
+
+func findHelmChart(index *helmIndex, name, version string) *helmChartVersion {
+	for _, chart := range index.Entries[name] {
+		if chart.Version == version {
+			return &chart
+		}
+	}
+	return nil
+}
+
+// getHelmChart finds a chart version in index.yaml of the last listing. A
+// version missing there, like one just uploaded, is looked up in the current index.
+func getHelmChart(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, name, version string) (*helmChartVersion, error) {
+	index, err := cachedHelmIndex(ctx, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	if chart := findHelmChart(index, name, version); chart != nil {
+		return chart, nil
+	}
+	index, err = getHelmIndex(ctx, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	if chart := findHelmChart(index, name, version); chart != nil {
+		return chart, nil
+	}
+	return nil, fmt.Errorf("chart %s-%s not found in %s", name, version, helmIndexURL(progetConfig))
+}
+
+func getHelmPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting helm charts")
+	index, err := getHelmIndex(ctx, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make([]Package, 0, len(index.Entries))
+	for name, charts := range index.Entries {
+		versions := make([]string, 0, len(charts))
+		for _, chart := range charts {
+			versions = append(versions, chart.Version)
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		packages = append(packages, Package{
+			Group:    "",
+			Name:     name,
+			Versions: versions,
+		})
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// helmDownloadURL returns the chart URL from the source index, relative URLs are resolved against index.yaml.
+func helmDownloadURL(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, name, version string) (string, error) {
+	chart, err := getHelmChart(ctx, progetConfig, timeoutConfig, name, version)
+	if err != nil {
+		return "", err
+	}
+	if len(chart.URLs) == 0 {
+		return "", fmt.Errorf("chart %s-%s has no urls", name, version)
+	}
+	return resolveURL(helmIndexURL(progetConfig), chart.URLs[0])
+}
+
+// getHelmHashes returns the digests both feeds publish for the chart in index.yaml.
+func getHelmHashes(ctx context.Context, chain SyncChain, name, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	srcChart, err := getHelmChart(ctx, chain.Source, timeoutConfig, name, version)
+	if err != nil {
+		return "", "", err
+	}
+	dstChart, err := getHelmChart(ctx, chain.Destination, timeoutConfig, name, version)
+	if err != nil {
+		return "", "", err
+	}
+	log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Success get hash %s:%s. digest: %s", name, version, dstChart.Digest)
+	return strings.ToLower(srcChart.Digest), strings.ToLower(dstChart.Digest), nil
+}
+
+type helmDriver struct{}
+
+func (helmDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getHelmPackages(ctx, feed, timeoutConfig)
+}
+
+func (helmDriver) CompareVersions(a, b string) int {
+	return compareSemver(a, b)
+}
+
+func (helmDriver) IsPrerelease(version string) bool {
+	return isSemverPrerelease(version)
+}
+
+func (helmDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	downloadURL, err := helmDownloadURL(ctx, feed, timeoutConfig, pkg.Name, version)
+	if err != nil {
+		return nil, err
+	}
+	filePath := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", pkg.Name, version))
+	hash, err := downloadWithRetries(ctx, downloadURL, filePath, feed, timeoutConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+func (helmDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	uploadURL := cleanURL(fmt.Sprintf("%s/helm/%s/upload", chain.Destination.URL, chain.Destination.Feed))
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, putRequest)
+}
+
+func (helmDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	return getHelmHashes(ctx, chain, pkg.Name, version, timeoutConfig)
+}
+
+// FeedHash reads the digest of the chart in index.yaml.
+func (helmDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	chart, err := getHelmChart(ctx, feed, timeoutConfig, pkg.Name, version)
+	if err != nil {
+		return "", "", err
+	}
+	return "sha256", strings.ToLower(chart.Digest), nil
+}
+
+func (helmDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	return deletePackage(ctx, feed, pkg, version, url.Values{"name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// PublishDates reads the created field of index.yaml.
+func (helmDriver) PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error) {
+	index, err := cachedHelmIndex(ctx, feed, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time)
+	for _, chart := range index.Entries[pkg.Name] {
+		if created, ok := parsePublishTime(chart.Created); ok {
+			dates[chart.Version] = created
+		}
+	}
+	return dates, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,6 +25,7 @@ var (
 	debug       = new(bool)
 	metrics     = new(bool)
 	metricsPort = new(int)
+	reportPath  = new(string)
 )
 
 func init() {
@@ -33,11 +35,18 @@ func init() {
 	flag.BoolVar(debug, "debug", false, "debug mode")
 	flag.BoolVar(metrics, "metrics", false, "enable metrics publish")
 	flag.IntVar(metricsPort, "metrics-port", 9464, "port for publish metric. Default 9464")
-	flag.Parse()
+	flag.StringVar(reportPath, "report", "retention-report", "path of retention dry-run report, written as .json and .csv")
+}
 
+// startMetrics registers the metrics and serves them when -metrics is set.
+func startMetrics() {
 	if *metrics {
 		prometheus.MustRegister(HttpRequestsTotal)
 		prometheus.MustRegister(PackageProceedTotal)
+		prometheus.MustRegister(NugetPagesFetchedTotal)
+		prometheus.MustRegister(DeleteQueueDepth)
+		prometheus.MustRegister(SyncConflicts)
+		prometheus.MustRegister(FilteredTotal)
 		go func() {
 			http.Handle("/metrics", promhttp.Handler())
 			log.Info().Msgf("Starting metrics server on :%d", *metricsPort)
@@ -48,10 +57,12 @@ func init() {
 			}
 		}()
 	}
-
 }
 
 func main() {
+	flag.Parse()
+	startMetrics()
+
 	logFile, err := setupLogging(*logFilePath)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to open log file")
@@ -59,6 +70,21 @@ func main() {
 	}
 	defer logFile.Close()
 
+	if flag.Arg(0) == "restore" {
+		if flag.NArg() != 2 {
+			log.Fatal().Msg("Usage: restore <sidecar path or archived version hash>")
+		}
+		config, err := readConfig(*configFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to read config")
+		}
+		err = restoreArchived(context.Background(), config, flag.Arg(1))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Restore failed")
+		}
+		return
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
@@ -68,12 +94,6 @@ func main() {
 			log.Fatal().Err(err).Msg("Failed to read config")
 		}
 
-		if config.Retention.Enabled && !config.Retention.DryRun {
-			if config.ProceedPackageVersion > config.Retention.VersionLimit {
-				config.ProceedPackageVersion = config.Retention.VersionLimit
-			}
-		}
-
 		log.Info().Msgf("Clean %s", *savePath)
 		err = createDeleteDirectoryContents(*savePath)
 		if err != nil {
@@ -96,6 +116,8 @@ func main() {
 		if *metrics {
 			HttpRequestsTotal.Reset()
 			PackageProceedTotal.Reset()
+			NugetPagesFetchedTotal.Reset()
+			FilteredTotal.Reset()
 		}
 
 		select {
@@ -116,110 +138,230 @@ func run() error {
 		log.Fatal().Err(err).Msg("Failed to read config")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeout.SyncTimeout)*time.Second)
+	// every chain gets its own syncTimeout deadline in syncChain
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	resetNugetV3IndexCache()
+	syncIteration++
 
+	queue, err := loadDeleteQueue(config.DeleteQueue.File)
+	if err != nil {
+		// the queue is rebuilt by retention, only the delete budget is lost
+		log.Error().Err(err).Msg("Failed to read delete queue, starting with an empty one")
+	}
+
+	report := &retentionReport{}
+
+	pool := newTransferPool(config.Concurrency)
+	defer pool.close()
+
+	// chains run in parallel, their transfers share the workers of the pool
 	log.Debug().Msgf("Chain sync loop start. Found %d chains", len(config.SyncChain))
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(config.SyncChain))
 	for _, chain := range config.SyncChain {
-		log.Debug().Msg("Parsing URL")
-		_, err := url.ParseRequestURI(chain.Source.URL)
+		wg.Add(1)
+		go func(chain SyncChain) {
+			defer wg.Done()
+			chainConfig := config.forChain(chain)
+			err := syncChain(ctx, chainConfig, chain, pool, queue, report)
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Warn().Str("source", chain.Source.Feed).Str("destination", chain.Destination.Feed).Msgf("Chain sync timeout. Timeout: %d seconds", chainConfig.Timeout.SyncTimeout)
+			}
+			if err != nil {
+				errCh <- err
+			}
+		}(chain)
+	}
+	wg.Wait()
+	close(errCh)
+
+	// written before the pause, a stop signal during it exits the process
+	writeRetentionReport(report)
+
+	for err := range errCh {
+		return err
+	}
+	log.Info().Msgf("Pausing for %d seconds", config.Timeout.IterationTimeout)
+	time.Sleep((time.Duration(config.Timeout.IterationTimeout) / 2) * time.Second)
+	return nil
+}
+
+// writeRetentionReport writes the dry-run decisions of the iteration, if any.
+func writeRetentionReport(report *retentionReport) {
+	if len(report.Entries) == 0 {
+		return
+	}
+	err := report.write(*reportPath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write retention report")
+		return
+	}
+	log.Info().Msgf("Retention dry-run report written to %s.json and %s.csv", *reportPath, *reportPath)
+}
+
+// syncChain syncs one chain with its effective configuration and runs
+// retention. Only failed version syncs are returned, other errors are logged.
+func syncChain(ctx context.Context, config *Config, chain SyncChain, pool *transferPool, queue *deleteQueue, report *retentionReport) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.Timeout.SyncTimeout)*time.Second)
+	defer cancel()
+
+	log.Debug().Msg("Parsing URL")
+	var err error
+	for _, source := range chain.Sources {
+		_, err = url.ParseRequestURI(source.URL)
 		if err != nil {
 			log.Error().Err(err).Msg("Invalid source URI")
 		}
+	}
 
-		_, err = url.ParseRequestURI(chain.Destination.URL)
+	for _, destination := range chain.Destinations {
+		_, err = url.ParseRequestURI(destination.URL)
 		if err != nil {
 			log.Error().Err(err).Msg("Invalid destination URI")
 		}
+	}
 
-		select {
-		case <-ctx.Done():
-			log.Warn().Msgf("Timeout or cancel signal received, exiting run. Timeout: %d seconds", config.Timeout.SyncTimeout)
-			return ctx.Err()
-		default:
-			sourcePackages, err := getPackages(ctx, chain.Source, config.Timeout)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get packages from source")
-				continue
-			}
+	sourcePackages, sources, err := getSourcePackages(ctx, config, chain)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get packages from source")
+		return nil
+	}
 
-			destPackages, err := getPackages(ctx, chain.Destination, config.Timeout)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get packages from destination")
-				continue
-			}
+	var targets []fanOutTarget
+	for _, destination := range chain.Destinations {
+		target := fanOutTarget{chain: chain.withDestination(destination)}
+		target.destPackages, err = getPackages(ctx, destination, config.Timeout)
+		if err != nil {
+			log.Error().Err(err).Str("url", destination.URL).Str("feed", destination.Feed).Msg("Failed to get packages from destination")
+			continue
+		}
+		target.syncPackages, err = getPackagesToSync(ctx, config, target.chain, sourcePackages, target.destPackages)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to SyncChain packages")
+			continue
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
 
-			syncPackages, err := getPackagesToSync(config, chain, sourcePackages, destPackages)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to SyncChain packages")
-				continue
+	if len(chain.Destinations) > 1 {
+		err = transferFanOut(ctx, config, chain, pool, queue, sourcePackages, sources, targets)
+	} else {
+		err = transferPackages(ctx, config, chain, pool, queue, targets[0].syncPackages, sources)
+		if chain.Mode == "bidirectional" {
+			reverseErr := syncBack(ctx, config, chain, pool, queue, sourcePackages, targets[0].destPackages)
+			if err == nil {
+				err = reverseErr
 			}
+		}
+	}
+	if err != nil {
+		// a failed version does not stop mirror and retention, the error is returned after them
+		log.Error().Err(err).Msg("Failed to sync packages")
+	}
 
-			log.Debug().Msgf("syncPackages = %d", len(syncPackages))
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for _, target := range targets {
+		cleanDestination(ctx, config, target.chain, sourcePackages, target.destPackages, queue, report)
+	}
+	return err
+}
 
-			if len(syncPackages) > config.ProceedPackageLimit {
-				syncPackages = syncPackages[:config.ProceedPackageLimit]
-				newSourcePackages := make([]Package, len(syncPackages))
-				copy(newSourcePackages, syncPackages)
-				syncPackages = newSourcePackages
-			}
+// cleanupMu serializes mirror and retention of the chains running in
+// parallel, they share the delete queue and the report.
+var cleanupMu sync.Mutex
 
-			for i := range syncPackages {
-				if len(syncPackages[i].Versions) > config.ProceedPackageVersion {
-					syncPackages[i].Versions = syncPackages[i].Versions[:config.ProceedPackageVersion]
-				}
-			}
+// cleanDestination runs mirror and retention on one destination of the chain.
+func cleanDestination(ctx context.Context, config *Config, chain SyncChain, sourcePackages, destPackages []Package, queue *deleteQueue, report *retentionReport) {
+	if chain.Mode == "mirror" {
+		log.Info().Str("feed", chain.Destination.Feed).Msgf("Start mirror")
+		err := mirror(ctx, config, chain, sourcePackages, destPackages, queue)
+		if err != nil {
+			log.Error().Err(err).Msg("Mirror failed")
+		}
+	}
 
-			log.Info().Msgf("Will sync %d packages with %d versions", len(syncPackages), config.ProceedPackageVersion)
+	if config.Retention.Enabled && chain.Type == "asset" {
+		log.Info().Str("feed", chain.Destination.Feed).Msgf("Start asset retention")
+		err := assetRetention(ctx, config, chain, queue, report)
+		if err != nil {
+			log.Error().Err(err).Msg("Retention failed")
+		}
+	} else if config.Retention.Enabled {
+		log.Info().Str("feed", chain.Destination.Feed).Msgf("Start retention")
+		destPackages, err := getPackages(ctx, chain.Destination, config.Timeout)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get packages from destination")
+			return
+		}
+		err = retention(ctx, config, chain, destPackages, queue, report)
+		if err != nil {
+			log.Error().Err(err).Msg("Retention failed")
+		}
+	}
 
-			var packageList strings.Builder
-			for _, pkg := range syncPackages {
-				packageList.WriteString(fmt.Sprintf("%s/%s: %s | ", pkg.Group, pkg.Name, strings.Join(pkg.Versions, " ")))
-			}
-			log.Info().Str("url", chain.Destination.URL).Msg(packageList.String())
-
-			var wg sync.WaitGroup
-			errCh := make(chan error, len(syncPackages))
-
-			for _, pkg := range syncPackages {
-				for _, version := range pkg.Versions {
-					wg.Add(1)
-					go func(pkg Package, version string) {
-						defer wg.Done()
-						err := downloadAndUploadPackage(ctx, config, chain, pkg, version, *savePath)
-						if err != nil {
-							errCh <- fmt.Errorf("failed to sync package %s/%s:%s, error: %w", pkg.Group, pkg.Name, version, err)
-						}
-					}(pkg, version)
-				}
-			}
+	// mirror and retention send the queued deletions with their own, hash mismatches queued before are sent here
+	if chain.Mode != "mirror" && !config.Retention.Enabled {
+		err := queue.flush(ctx, config, chain)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to process delete queue")
+		}
+	}
+}
 
-			wg.Wait()
-			close(errCh)
+// transferPackages syncs the versions missing on the destination of the chain
+// within the package and version limits and returns the first failed version.
+// The versions are transferred by the pool, at most concurrency.perChain at once.
+func transferPackages(ctx context.Context, config *Config, chain SyncChain, pool *transferPool, queue *deleteQueue, syncPackages []Package, sources versionSources) error {
+	log.Debug().Msgf("syncPackages = %d", len(syncPackages))
+	syncPackages = limitPackages(config, syncPackages)
 
-			for err := range errCh {
-				return err
-			}
+	log.Info().Msgf("Will sync %d packages with %d versions", len(syncPackages), config.ProceedPackageVersion)
 
-			if config.Retention.Enabled && chain.Type != "asset" {
-				log.Info().Str("feed", chain.Destination.Feed).Msgf("Start retention")
-				destPackages, err = getPackages(ctx, chain.Destination, config.Timeout)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to get packages from destination")
-				}
-				err = retention(ctx, config, chain, destPackages)
+	var packageList strings.Builder
+	for _, pkg := range syncPackages {
+		packageList.WriteString(fmt.Sprintf("%s/%s: %s | ", pkg.Group, pkg.Name, strings.Join(pkg.Versions, " ")))
+	}
+	log.Info().Str("url", chain.Destination.URL).Msg(packageList.String())
+
+	group := pool.group(config.Concurrency.PerChain)
+	for _, pkg := range syncPackages {
+		for _, version := range pkg.Versions {
+			pkg, version := pkg, version
+			feeds := []ProgetConfig{sources.chainFor(chain, pkg, version).Source, chain.Destination}
+			err := group.submit(ctx, feeds, func(ctx context.Context) error {
+				err := downloadAndUploadPackage(ctx, config, chain, queue, sources, pkg, version, *savePath)
 				if err != nil {
-					log.Error().Err(err).Msg("Retention failed")
+					return fmt.Errorf("failed to sync package %s/%s:%s, error: %w", pkg.Group, pkg.Name, version, err)
 				}
+				return nil
+			})
+			if err != nil {
+				group.wait()
+				return err
 			}
 		}
 	}
-	log.Info().Msgf("Pausing for %d seconds", config.Timeout.IterationTimeout)
-	select {
-	case <-ctx.Done():
-		log.Warn().Msgf("Timeout or cancel signal received, exiting run. Timeout: %d seconds", config.Timeout.SyncTimeout)
-		return ctx.Err()
-	case <-time.After((time.Duration(config.Timeout.IterationTimeout) / 2) * time.Second):
-		return nil
+	return group.wait()
+}
+
+// limitPackages cuts the packages to proceedPackageLimit and the versions of each to proceedPackageVersion.
+func limitPackages(config *Config, syncPackages []Package) []Package {
+	if len(syncPackages) > config.ProceedPackageLimit {
+		syncPackages = syncPackages[:config.ProceedPackageLimit]
+	}
+	newSourcePackages := make([]Package, len(syncPackages))
+	copy(newSourcePackages, syncPackages)
+	syncPackages = newSourcePackages
+
+	for i := range syncPackages {
+		if len(syncPackages[i].Versions) > config.ProceedPackageVersion {
+			syncPackages[i].Versions = syncPackages[i].Versions[:config.ProceedPackageVersion]
+		}
 	}
+	return syncPackages
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const mavenMaxDepth = 32
+
+var (
+	mavenHrefRegexp       = regexp.MustCompile(`href="([^"]+)"`)
+	mavenChecksumSuffixes = []string{".sha1", ".md5", ".sha256", ".sha512"}
+)
+
+type mavenMetadata struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Versioning struct {
+		Versions []string `xml:"versions>version"`
+	} `xml:"versioning"`
+}
+
+func mavenFeedURL(progetConfig ProgetConfig) string {
+	return cleanURL(fmt.Sprintf("%s/maven2/%s", progetConfig.URL, progetConfig.Feed))
+}
+
+// mavenVersionPath returns groupId/artifactId/version as a repository path.
+func mavenVersionPath(pkg Package, version string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.ReplaceAll(pkg.Group, ".", "/"), pkg.Name, version)
+}
+
+func isMavenChecksum(name string) bool {
+	for _, suffix := range mavenChecksumSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listMavenDir parses the directory listing of the feed. Directory names end with "/".
+func listMavenDir(ctx context.Context, client *http.Client, dirURL string, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]string, error) {
+	body, err, _ := getBody(ctx, client, dirURL+"/", progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(dirURL + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	seen := make(map[string]bool)
+	for _, match := range mavenHrefRegexp.FindAllStringSubmatch(string(body), -1) {
+		ref, err := url.Parse(match[1])
+		if err != nil {
+			continue
+		}
+		target := base.ResolveReference(ref)
+		if target.Host != base.Host || !strings.HasPrefix(target.Path, base.Path) {
+			continue
+		}
+		entry := strings.TrimPrefix(target.Path, base.Path)
+		if entry == "" || entry == "/" || strings.Contains(strings.TrimSuffix(entry, "/"), "/") || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// getMavenPackages walks the feed directories. A directory with
+// maven-metadata.xml is an artifact, its metadata gives groupId, artifactId
+// and the versions.
+func getMavenPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting maven packages")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	var packages []Package
+	var walk func(dirURL string, depth int) error
+	walk = func(dirURL string, depth int) error {
+		if depth > mavenMaxDepth {
+			return fmt.Errorf("maven feed %s is nested deeper than %d directories", progetConfig.Feed, mavenMaxDepth)
+		}
+		entries, err := listMavenDir(ctx, client, dirURL, progetConfig, timeoutConfig)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry != "maven-metadata.xml" {
+				continue
+			}
+			body, err, _ := getBody(ctx, client, dirURL+"/"+entry, progetConfig, timeoutConfig)
+			if err != nil {
+				return err
+			}
+			var metadata mavenMetadata
+			err = xml.Unmarshal(body, &metadata)
+			if err != nil {
+				return fmt.Errorf("failed to decode %s/%s: %w", dirURL, entry, err)
+			}
+			// group level metadata of plugins has no artifactId
+			if metadata.ArtifactID == "" {
+				break
+			}
+			versions := metadata.Versioning.Versions
+			// maven-metadata.xml lists versions oldest first
+			for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+				versions[i], versions[j] = versions[j], versions[i]
+			}
+			if len(versions) > 0 {
+				packages = append(packages, Package{
+					Group:    metadata.GroupID,
+					Name:     metadata.ArtifactID,
+					Versions: versions,
+				})
+			}
+			return nil
+		}
+
+		for _, entry := range entries {
+			if strings.HasSuffix(entry, "/") {
+				err = walk(dirURL+"/"+strings.TrimSuffix(entry, "/"), depth+1)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := walk(mavenFeedURL(progetConfig), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+type mavenDriver struct{}
+
+func (mavenDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getMavenPackages(ctx, feed, timeoutConfig)
+}
+
+func (mavenDriver) CompareVersions(a, b string) int {
+	return compareMavenVersions(a, b)
+}
+
+func (mavenDriver) IsPrerelease(version string) bool {
+	return isMavenPrerelease(version)
+}
+
+// Download saves every file of one artifact version, checksum files last, and
+// checks the artifacts against the checksums published next to them.
+func (mavenDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	srcDirURL := mavenFeedURL(feed) + "/" + mavenVersionPath(pkg, version)
+
+	entries, err := listMavenDir(ctx, client, srcDirURL, feed, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	published := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry, "/") {
+			continue
+		}
+		names = append(names, entry)
+		published[entry] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no files found in %s", srcDirURL)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return !isMavenChecksum(names[i]) && isMavenChecksum(names[j])
+	})
+
+	files := make([]transferFile, 0, len(names))
+	for _, name := range names {
+		filePath := filepath.Join(dir, name)
+		hash, err := downloadWithRetries(ctx, srcDirURL+"/"+name, filePath, feed, timeoutConfig, func(hash fileHash) error {
+			return checkMavenChecksums(ctx, client, feed, srcDirURL, name, hash, published, timeoutConfig)
+		})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, transferFile{Path: filePath, Hash: hash})
+	}
+	return files, nil
+}
+
+func (mavenDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	dstDirURL := mavenFeedURL(chain.Destination) + "/" + mavenVersionPath(pkg, version)
+	for _, file := range files {
+		err := uploadWithRetries(ctx, dstDirURL+"/"+filepath.Base(file.Path), file.Path, chain.Destination, timeoutConfig, putRequest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mavenDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	dstDirURL := mavenFeedURL(chain.Destination) + "/" + mavenVersionPath(pkg, version)
+	return getMavenHashes(ctx, client, chain.Destination, dstDirURL, files, timeoutConfig)
+}
+
+func (mavenDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	return deletePackage(ctx, feed, pkg, version, url.Values{"group": {pkg.Group}, "name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// checkMavenChecksums compares a downloaded artifact with the .sha1/.md5 files published next to it on the source.
+func checkMavenChecksums(ctx context.Context, client *http.Client, source ProgetConfig, srcDirURL, file string, hash fileHash, published map[string]bool, timeoutConfig TimeoutConfig) error {
+	if isMavenChecksum(file) {
+		return nil
+	}
+	for _, checksum := range []struct{ suffix, actual string }{{".sha1", hash.SHA1}, {".md5", hash.MD5}} {
+		if !published[file+checksum.suffix] {
+			continue
+		}
+		body, err, _ := getBody(ctx, client, srcDirURL+"/"+file+checksum.suffix, source, timeoutConfig)
+		if err != nil {
+			return err
+		}
+		if expected := parseMavenChecksum(body); expected != checksum.actual {
+			return fmt.Errorf("%s%s mismatch: published %s, downloaded %s", file, checksum.suffix, expected, checksum.actual)
+		}
+	}
+	return nil
+}
+
+// getMavenHashes lists "file sha1" of every artifact as downloaded and as
+// published by the destination in its .sha1 files.
+func getMavenHashes(ctx context.Context, client *http.Client, destination ProgetConfig, dstDirURL string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	var src, dst strings.Builder
+	for _, file := range files {
+		name := filepath.Base(file.Path)
+		if isMavenChecksum(name) {
+			continue
+		}
+		src.WriteString(fmt.Sprintf("%s %s\n", name, file.Hash.SHA1))
+
+		body, err, statusCode := getBody(ctx, client, dstDirURL+"/"+name+".sha1", destination, timeoutConfig)
+		if err == nil {
+			dst.WriteString(fmt.Sprintf("%s %s\n", name, parseMavenChecksum(body)))
+			continue
+		}
+		if statusCode != http.StatusNotFound {
+			return "", "", err
+		}
+
+		// the destination publishes no checksum, hash the stored file instead
+		filePath := file.Path + ".verify"
+		stored, err, _ := downloadFile(ctx, dstDirURL+"/"+name, filePath, destination, timeoutConfig)
+		os.Remove(filePath)
+		if err != nil {
+			return "", "", err
+		}
+		dst.WriteString(fmt.Sprintf("%s %s\n", name, stored.SHA1))
+	}
+	return src.String(), dst.String(), nil
+}
+
+// parseMavenChecksum reads the first token of a checksum file, some tools append the file name.
+func parseMavenChecksum(body []byte) string {
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
@@ -20,4 +20,36 @@ var (
 		},
 		[]string{"feed"},
 	)
+
+	NugetPagesFetchedTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "updater_nuget_pages_fetched_total",
+			Help: "Total number of NuGet OData pages fetched by one loop.",
+		},
+		[]string{"feed"},
+	)
+
+	DeleteQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "updater_delete_queue_depth",
+			Help: "Number of retention deletions waiting in the delete queue.",
+		},
+		[]string{"url", "feed"},
+	)
+
+	SyncConflicts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "updater_sync_conflicts",
+			Help: "Number of versions of bidirectional chains present on both feeds with different hashes.",
+		},
+		[]string{"url", "feed"},
+	)
+
+	FilteredTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "updater_filtered_total",
+			Help: "Number of source packages and versions filtered out by chain filters by one loop.",
+		},
+		[]string{"feed", "kind"},
+	)
 )
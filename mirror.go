@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// defaultMirrorThreshold is the percent of destination versions mirror mode may delete in one iteration.
+const defaultMirrorThreshold = 10
+
+// mirrorThreshold returns the configured mirrorThreshold, defaultMirrorThreshold when it is not set.
+func (chain SyncChain) mirrorThreshold() float64 {
+	if chain.MirrorThreshold == nil {
+		return defaultMirrorThreshold
+	}
+	return *chain.MirrorThreshold
+}
+
+// mirror queues the destination versions absent on the source for deletion and
+// sends the deletions the delete budget allows. When more than mirrorThreshold
+// percent of the destination would be deleted, the source listing is likely
+// incomplete, so nothing is deleted and the queued mirror deletions are dropped.
+func mirror(ctx context.Context, config *Config, chain SyncChain, sourcePackages, destPackages []Package, queue *deleteQueue) error {
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+	// packages the filter leaves out are not synced, so they are not deleted either
+	sourcePackages, _, _ = filterPackages(chain.Filter, driver, sourcePackages)
+	destPackages, _, _ = filterPackages(chain.Filter, driver, destPackages)
+	// source versions are compared under the group and name they have on the destination
+	remapped := make([]Package, len(sourcePackages))
+	for i, pkg := range sourcePackages {
+		remapped[i] = chain.remap(pkg)
+	}
+	sourcePackages = remapped
+	packages, percent := getPackagesToMirror(driver, sourcePackages, destPackages)
+	if percent > chain.mirrorThreshold() {
+		err = queue.clear(chain.Destination, deleteKindMirror)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("mirror would delete %.1f%% of destination versions, more than mirrorThreshold %.1f%%", percent, chain.mirrorThreshold())
+	}
+
+	now := time.Now()
+	var items []deleteQueueItem
+	for _, pkg := range packages {
+		for _, version := range pkg.Versions {
+			log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Mirror").Msgf("Queue delete %s/%s:%s: absent on source", pkg.Group, pkg.Name, version)
+			items = append(items, deleteQueueItem{
+				URL:     chain.Destination.URL,
+				Feed:    chain.Destination.Feed,
+				Group:   pkg.Group,
+				Name:    pkg.Name,
+				Version: version,
+				Reason:  "absent on source",
+				Queued:  now,
+			})
+		}
+	}
+	log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Mirror").Msgf("%d versions absent on source", len(items))
+	return queue.commit(ctx, config, chain, deleteKindMirror, items)
+}
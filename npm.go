@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const npmSearchPageSize = 250
+
+type npmSearchResponse struct {
+	Objects []struct {
+		Package struct {
+			Name string `json:"name"`
+		} `json:"package"`
+	} `json:"objects"`
+	Total int `json:"total"`
+}
+
+type npmPackument struct {
+	Name     string                     `json:"name"`
+	DistTags map[string]string          `json:"dist-tags"`
+	Versions map[string]json.RawMessage `json:"versions"`
+	Time     map[string]string          `json:"time"`
+}
+
+type npmDist struct {
+	Tarball   string `json:"tarball"`
+	Shasum    string `json:"shasum"`
+	Integrity string `json:"integrity"`
+}
+
+// npmManifestFile is stored next to the downloaded tarball, the upload step
+// needs the version manifest and tags to build the publish document.
+type npmManifestFile struct {
+	Manifest map[string]interface{} `json:"manifest"`
+	DistTags map[string]string      `json:"distTags"`
+}
+
+// npmPackageName joins the scope kept in Package.Group with the package name.
+func npmPackageName(pkg Package) string {
+	if pkg.Group == "" {
+		return pkg.Name
+	}
+	return pkg.Group + "/" + pkg.Name
+}
+
+// npmSplitName maps "@scope/name" onto Package.Group and Package.Name.
+func npmSplitName(name string) (string, string) {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.Index(name, "/"); i > 0 {
+			return name[:i], name[i+1:]
+		}
+	}
+	return "", name
+}
+
+func npmPackumentURL(progetConfig ProgetConfig, name string) string {
+	return cleanURL(fmt.Sprintf("%s/npm/%s/%s", progetConfig.URL, progetConfig.Feed, url.PathEscape(name)))
+}
+
+func getNpmPackument(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, name string) (*npmPackument, error, int) {
+	var packument npmPackument
+	err, statusCode := getJSON(ctx, client, npmPackumentURL(progetConfig, name), progetConfig, timeoutConfig, &packument)
+	if err != nil {
+		return nil, err, statusCode
+	}
+	return &packument, nil, statusCode
+}
+
+// getNpmPackages lists package names through the registry search endpoint and
+// reads versions from every packument. Versions are ordered newest first by publish time.
+func getNpmPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting npm packages")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for from := 0; ; from += npmSearchPageSize {
+		query := url.Values{}
+		query.Set("text", "")
+		query.Set("size", strconv.Itoa(npmSearchPageSize))
+		query.Set("from", strconv.Itoa(from))
+		searchURL := cleanURL(fmt.Sprintf("%s/npm/%s/-/v1/search?%s", progetConfig.URL, progetConfig.Feed, query.Encode()))
+
+		var search npmSearchResponse
+		err, _ := getJSON(ctx, client, searchURL, progetConfig, timeoutConfig, &search)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range search.Objects {
+			if !seen[object.Package.Name] {
+				seen[object.Package.Name] = true
+				names = append(names, object.Package.Name)
+			}
+		}
+		if len(search.Objects) < npmSearchPageSize {
+			break
+		}
+	}
+
+	packages := make([]Package, 0, len(names))
+	for _, name := range names {
+		packument, err, _ := getNpmPackument(ctx, client, progetConfig, timeoutConfig, name)
+		if err != nil {
+			return nil, err
+		}
+		versions := make([]string, 0, len(packument.Versions))
+		for version := range packument.Versions {
+			versions = append(versions, version)
+		}
+		sort.SliceStable(versions, func(i, j int) bool {
+			ti, tj := packument.Time[versions[i]], packument.Time[versions[j]]
+			if ti != tj {
+				return ti > tj
+			}
+			return versions[i] > versions[j]
+		})
+		if len(versions) == 0 {
+			continue
+		}
+		group, pkgName := npmSplitName(name)
+		packages = append(packages, Package{
+			Group:    group,
+			Name:     pkgName,
+			Versions: versions,
+		})
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// prepareNpmTransfer reads the version manifest from the source packument,
+// stores it next to filePath and returns the tarball URL to download.
+func prepareNpmTransfer(ctx context.Context, source ProgetConfig, pkg Package, version, filePath string, timeoutConfig TimeoutConfig) (string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	packument, err, _ := getNpmPackument(ctx, client, source, timeoutConfig, npmPackageName(pkg))
+	if err != nil {
+		return "", err
+	}
+	raw, ok := packument.Versions[version]
+	if !ok {
+		return "", fmt.Errorf("version %s not found in packument of %s", version, npmPackageName(pkg))
+	}
+
+	manifestFile := npmManifestFile{DistTags: make(map[string]string)}
+	err = json.Unmarshal(raw, &manifestFile.Manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode manifest of %s@%s: %w", npmPackageName(pkg), version, err)
+	}
+	for tag, tagVersion := range packument.DistTags {
+		if tagVersion == version {
+			manifestFile.DistTags[tag] = version
+		}
+	}
+
+	var manifest struct {
+		Dist npmDist `json:"dist"`
+	}
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil || manifest.Dist.Tarball == "" {
+		return "", fmt.Errorf("no dist.tarball for %s@%s", npmPackageName(pkg), version)
+	}
+
+	data, err := json.Marshal(manifestFile)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filePath+".json", data, 0666)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Dist.Tarball, nil
+}
+
+// npmPublishDocument builds the body of PUT /<name> the same way "npm publish" does.
+func npmPublishDocument(URL, filePath string) ([]byte, error) {
+	tarball, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filePath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var manifestFile npmManifestFile
+	err = json.Unmarshal(data, &manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := manifestFile.Manifest
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return nil, fmt.Errorf("manifest of %s has no name or version", filePath)
+	}
+
+	attachment := path.Base(filePath)
+	if dist, ok := manifest["dist"].(map[string]interface{}); ok {
+		dist["tarball"] = fmt.Sprintf("%s/-/%s", URL, attachment)
+	}
+
+	document := map[string]interface{}{
+		"_id":       name,
+		"name":      name,
+		"dist-tags": manifestFile.DistTags,
+		"versions": map[string]interface{}{
+			version: manifest,
+		},
+		"_attachments": map[string]interface{}{
+			attachment: map[string]interface{}{
+				"content_type": "application/octet-stream",
+				"data":         base64.StdEncoding.EncodeToString(tarball),
+				"length":       len(tarball),
+			},
+		},
+	}
+	return json.Marshal(document)
+}
+
+// getNpmHashes returns the hash of the downloaded tarball and the one the
+// destination publishes in dist.integrity (sha512) or dist.shasum (sha1), hex encoded.
+func getNpmHashes(ctx context.Context, destination ProgetConfig, pkg Package, version string, downloaded fileHash, timeoutConfig TimeoutConfig) (string, string, error) {
+	algorithm, hash, err := getNpmDistHash(ctx, destination, pkg, version, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+	if algorithm == "sha512" {
+		return downloaded.SHA512, hash, nil
+	}
+	return downloaded.SHA1, hash, nil
+}
+
+// getNpmDistHash returns the sha512 of dist.integrity, or the sha1 of dist.shasum without one.
+func getNpmDistHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	packument, err, _ := getNpmPackument(ctx, client, feed, timeoutConfig, npmPackageName(pkg))
+	if err != nil {
+		return "", "", err
+	}
+	raw, ok := packument.Versions[version]
+	if !ok {
+		return "", "", fmt.Errorf("version %s not found on %s/%s for %s", version, feed.URL, feed.Feed, npmPackageName(pkg))
+	}
+	var manifest struct {
+		Dist npmDist `json:"dist"`
+	}
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return "", "", err
+	}
+
+	if strings.HasPrefix(manifest.Dist.Integrity, "sha512-") {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(manifest.Dist.Integrity, "sha512-"))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid integrity %s: %w", manifest.Dist.Integrity, err)
+		}
+		return "sha512", hex.EncodeToString(sum), nil
+	}
+	if manifest.Dist.Shasum == "" {
+		return "", "", fmt.Errorf("%s/%s has no dist.shasum for %s@%s", feed.URL, feed.Feed, npmPackageName(pkg), version)
+	}
+	return "sha1", strings.ToLower(manifest.Dist.Shasum), nil
+}
+
+type npmDriver struct{}
+
+func (npmDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getNpmPackages(ctx, feed, timeoutConfig)
+}
+
+func (npmDriver) CompareVersions(a, b string) int {
+	return compareSemver(a, b)
+}
+
+func (npmDriver) IsPrerelease(version string) bool {
+	return isSemverPrerelease(version)
+}
+
+func (npmDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	filePath := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", pkg.Name, version))
+	downloadURL, err := prepareNpmTransfer(ctx, feed, pkg, version, filePath, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := downloadWithRetries(ctx, downloadURL, filePath, feed, timeoutConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+func (npmDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	uploadURL := npmPackumentURL(chain.Destination, npmPackageName(pkg))
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, npmUploadRequest)
+}
+
+func (npmDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	return getNpmHashes(ctx, chain.Destination, pkg, version, files[0].Hash, timeoutConfig)
+}
+
+// FeedHash reads dist.integrity or dist.shasum of the packument.
+func (npmDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	return getNpmDistHash(ctx, feed, pkg, version, timeoutConfig)
+}
+
+// PublishDates reads the time map of the packument.
+func (npmDriver) PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	packument, err, _ := getNpmPackument(ctx, client, feed, timeoutConfig, npmPackageName(pkg))
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time)
+	for version := range packument.Versions {
+		if published, ok := parsePublishTime(packument.Time[version]); ok {
+			dates[version] = published
+		}
+	}
+	return dates, nil
+}
+
+func (npmDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	return deletePackage(ctx, feed, pkg, version, url.Values{"group": {strings.TrimPrefix(pkg.Group, "@")}, "name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// npmUploadRequest publishes the tarball with the manifest stored next to it.
+func npmUploadRequest(ctx context.Context, URL string, file *os.File, feed ProgetConfig) (*http.Request, error) {
+	document, err := npmPublishDocument(URL, file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create publish document: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", URL, bytes.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nugetPageSize        = 100
+	defaultNugetMaxPages = 1000
+)
+
+// getNugetPackages lists a NuGet v2 feed through the OData Packages endpoint.
+// ProGet pages the response, so the Atom "next" link is followed until the
+// last page. If the server gives no link but returns a full page, paging
+// falls back to $skip/$top.
+func getNugetPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	var packages []Package
+
+	maxPages := progetConfig.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultNugetMaxPages
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	skip := 0
+	pageURL := nugetPageURL(progetConfig, skip)
+	for page := 1; pageURL != ""; page++ {
+		if page > maxPages {
+			return nil, fmt.Errorf("nuget feed %s has more than %d pages, increase maxPages", progetConfig.Feed, maxPages)
+		}
+
+		body, err := getNugetPage(ctx, client, pageURL, progetConfig, timeoutConfig)
+		if err != nil {
+			return nil, err
+		}
+		NugetPagesFetchedTotal.With(prometheus.Labels{"feed": progetConfig.Feed}).Inc()
+
+		var (
+			next    string
+			entries int
+		)
+		packages, next, entries, err = decodeXML(string(body), packages)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding package list page %d: %w", page, err)
+		}
+		log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Page %d: %d entries", page, entries)
+
+		skip += entries
+		switch {
+		case next != "":
+			pageURL, err = resolveURL(pageURL, next)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next link %s: %w", next, err)
+			}
+		case entries >= nugetPageSize:
+			pageURL = nugetPageURL(progetConfig, skip)
+		default:
+			pageURL = ""
+		}
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+func getNugetPage(ctx context.Context, client *http.Client, pageURL string, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ApiKey", progetConfig.APIKey)
+
+	for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+		log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Attempt %d to get package list page %s", attempt, pageURL)
+		resp, body, err := apiCall(client, req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			if resp != nil {
+				log.Error().Err(err).Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Attempt %d failed to get package page. Status: %s", attempt, resp.Status)
+			} else {
+				log.Error().Err(err).Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Attempt %d failed to get package page. Status is empty it nay be deadline", attempt)
+			}
+			time.Sleep(5 * time.Duration(attempt) * time.Second)
+			continue
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("failed to get package page %s after %d attempts", pageURL, timeoutConfig.MaxRetries)
+}
+
+func nugetPageURL(progetConfig ProgetConfig, skip int) string {
+	query := url.Values{}
+	query.Set("$skip", strconv.Itoa(skip))
+	query.Set("$top", strconv.Itoa(nugetPageSize))
+	return fmt.Sprintf("%s/%s/%s/packages?%s", progetConfig.URL, progetConfig.Type, progetConfig.Feed, query.Encode())
+}
+
+// resolveURL resolves a possibly relative link against the URL of the page it came from.
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// getNugetHashes returns the hash of the downloaded package and the hash of the
+// package stored on the destination in the same algorithm, both hex encoded.
+// The destination hash is taken from the OData PackageHash property; when the
+// feed does not expose it the stored package is downloaded again into dir,
+// the transfer directory of the version, and hashed.
+func getNugetHashes(ctx context.Context, destination ProgetConfig, name, version, dir string, downloaded fileHash, timeoutConfig TimeoutConfig) (string, string, error) {
+	if !isNugetV3(destination) {
+		hash, algorithm, err := getNugetODataHash(ctx, destination, name, version, timeoutConfig)
+		if err != nil {
+			log.Warn().Err(err).Str("url", destination.URL).Str("feed", destination.Feed).Msgf("Failed to get PackageHash of %s:%s, will download it", name, version)
+		} else if hash != "" {
+			switch strings.ToUpper(algorithm) {
+			case "SHA512", "":
+				return downloaded.SHA512, hash, nil
+			case "SHA1":
+				return downloaded.SHA1, hash, nil
+			default:
+				log.Warn().Str("url", destination.URL).Str("feed", destination.Feed).Msgf("Unknown PackageHashAlgorithm %s of %s:%s, will download it", algorithm, name, version)
+			}
+		}
+	}
+
+	var (
+		downloadURL string
+		err         error
+	)
+	if isNugetV3(destination) {
+		downloadURL, err = nugetV3DownloadURL(ctx, destination, timeoutConfig, name, version)
+		if err != nil {
+			return "", "", err
+		}
+	} else {
+		downloadURL = cleanURL(fmt.Sprintf("%s/%s/%s/package/%s/%s", destination.URL, destination.Type, destination.Feed, name, version))
+	}
+
+	filePath := filepath.Join(dir, fmt.Sprintf("%s.%s.verify.nupkg", name, version))
+	defer os.Remove(filePath)
+	for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+		stored, err, _ := downloadFile(ctx, downloadURL, filePath, destination, timeoutConfig)
+		if err == nil {
+			return downloaded.SHA512, stored.SHA512, nil
+		}
+		log.Error().Err(err).Str("url", destination.URL).Str("feed", destination.Feed).Str("Action", "Download").Msgf("Attempt: %d failed", attempt)
+		time.Sleep(5 * time.Duration(attempt) * time.Second)
+	}
+	return "", "", fmt.Errorf("failed to download %s:%s from destination to check hash", name, version)
+}
+
+// getNugetODataHash reads PackageHash and PackageHashAlgorithm of one package
+// version. The base64 hash is returned hex encoded.
+func getNugetODataHash(ctx context.Context, progetConfig ProgetConfig, name, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	entryURL := cleanURL(fmt.Sprintf("%s/%s/%s/Packages(Id='%s',Version='%s')", progetConfig.URL, progetConfig.Type, progetConfig.Feed, url.PathEscape(name), url.PathEscape(version)))
+
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+
+	body, err := getNugetPage(ctx, client, entryURL, progetConfig, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+
+	var hash, algorithm string
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "PackageHash":
+			err = decoder.DecodeElement(&hash, &se)
+		case "PackageHashAlgorithm":
+			err = decoder.DecodeElement(&algorithm, &se)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if hash == "" {
+		return "", "", nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(hash))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid PackageHash %s: %w", hash, err)
+	}
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Success get hash %s:%s. %s: %x", name, version, algorithm, raw)
+	return hex.EncodeToString(raw), algorithm, nil
+}
+
+// getNugetODataPublishDates reads Version and Published of the entries
+// FindPackagesById returns, following the "next" links of paged responses.
+func getNugetODataPublishDates(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, id string) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	pageURL := cleanURL(fmt.Sprintf("%s/%s/%s/FindPackagesById()?id=%s", progetConfig.URL, progetConfig.Type, progetConfig.Feed, url.QueryEscape("'"+id+"'")))
+	for {
+		body, err := getNugetPage(ctx, client, pageURL, progetConfig, timeoutConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		var version, published, next string
+		decoder := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			t, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch se := t.(type) {
+			case xml.StartElement:
+				switch se.Name.Local {
+				case "Version":
+					err = decoder.DecodeElement(&version, &se)
+				case "Published":
+					err = decoder.DecodeElement(&published, &se)
+				case "link":
+					for _, attr := range se.Attr {
+						if attr.Name.Local == "rel" && attr.Value == "next" {
+							for _, href := range se.Attr {
+								if href.Name.Local == "href" {
+									next = href.Value
+								}
+							}
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+			case xml.EndElement:
+				if se.Name.Local == "entry" {
+					if t, ok := parsePublishTime(published); ok && version != "" {
+						dates[version] = t
+					}
+					version, published = "", ""
+				}
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		pageURL, err = resolveURL(pageURL, next)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next link %s: %w", next, err)
+		}
+	}
+	return dates, nil
+}
+
+type nugetDriver struct{}
+
+func (nugetDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	if isNugetV3(feed) {
+		return getNugetV3Packages(ctx, feed, timeoutConfig)
+	}
+	return getNugetPackages(ctx, feed, timeoutConfig)
+}
+
+func (nugetDriver) CompareVersions(a, b string) int {
+	return compareNugetVersions(a, b)
+}
+
+// NormalizeVersion matches the original versions of OData feeds with the normalized ones of v3 feeds.
+func (nugetDriver) NormalizeVersion(version string) string {
+	return normalizeNugetVersion(version)
+}
+
+func (nugetDriver) IsPrerelease(version string) bool {
+	return isNugetPrerelease(version)
+}
+
+func (nugetDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	downloadURL := cleanURL(fmt.Sprintf("%s/%s/%s/package/%s/%s", feed.URL, feed.Type, feed.Feed, pkg.Name, version))
+	if isNugetV3(feed) {
+		var err error
+		downloadURL, err = nugetV3DownloadURL(ctx, feed, timeoutConfig, pkg.Name, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	filePath := filepath.Join(dir, fmt.Sprintf("%s.%s.nupkg", pkg.Name, version))
+	hash, err := downloadWithRetries(ctx, downloadURL, filePath, feed, timeoutConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+func (nugetDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	uploadURL := cleanURL(fmt.Sprintf("%s/%s/%s/upload", chain.Destination.URL, chain.Type, chain.Destination.Feed))
+	if isNugetV3(chain.Destination) {
+		var err error
+		uploadURL, err = nugetV3UploadURL(ctx, chain.Destination, timeoutConfig)
+		if err != nil {
+			return err
+		}
+	}
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, nugetUploadRequest)
+}
+
+func (nugetDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	return getNugetHashes(ctx, chain.Destination, pkg.Name, version, filepath.Dir(files[0].Path), files[0].Hash, timeoutConfig)
+}
+
+// FeedHash reads PackageHash of OData feeds, NuGet v3 feeds report none.
+func (nugetDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	if isNugetV3(feed) {
+		return "", "", nil
+	}
+	hash, algorithm, err := getNugetODataHash(ctx, feed, pkg.Name, version, timeoutConfig)
+	if algorithm == "" {
+		algorithm = "SHA512"
+	}
+	return strings.ToLower(algorithm), hash, err
+}
+
+func (nugetDriver) PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	if isNugetV3(feed) {
+		return getNugetV3PublishDates(ctx, client, feed, timeoutConfig, pkg.Name)
+	}
+	return getNugetODataPublishDates(ctx, client, feed, timeoutConfig, pkg.Name)
+}
+
+// Delete uses the ProGet packages API, v3 feeds with an explicit serviceIndex
+// are other NuGet servers and are deleted through PackagePublish.
+func (nugetDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	if isNugetV3(feed) && feed.ServiceIndex != "" {
+		return deleteNugetV3Package(ctx, feed, pkg, version, timeoutConfig)
+	}
+	return deletePackage(ctx, feed, pkg, version, url.Values{"name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// nugetUploadRequest sends the package as the "package" field of a multipart form.
+func nugetUploadRequest(ctx context.Context, URL string, file *os.File, feed ProgetConfig) (*http.Request, error) {
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+
+	part, err := writer.CreateFormFile("package", filepath.Base(file.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", URL, &requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if isNugetV3(feed) {
+		req.Header.Set("X-NuGet-ApiKey", feed.APIKey)
+	}
+	return req, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nugetV3SearchPageSize = 100
+
+// Resource types of the NuGet v3 service index, in order of preference.
+var (
+	nugetV3PackageBaseAddress = []string{"PackageBaseAddress/3.0.0"}
+	nugetV3PackagePublish     = []string{"PackagePublish/2.0.0"}
+	nugetV3SearchQueryService = []string{"SearchQueryService/3.5.0", "SearchQueryService/3.0.0-rc", "SearchQueryService/3.0.0-beta", "SearchQueryService"}
+	nugetV3Registrations      = []string{"RegistrationsBaseUrl/3.6.0", "RegistrationsBaseUrl/3.4.0", "RegistrationsBaseUrl/3.0.0-rc", "RegistrationsBaseUrl/3.0.0-beta", "RegistrationsBaseUrl"}
+)
+
+var (
+	nugetV3IndexCache   = make(map[string]map[string]string)
+	nugetV3IndexCacheMu sync.Mutex
+)
+
+type nugetV3ServiceIndex struct {
+	Resources []struct {
+		ID   string `json:"@id"`
+		Type string `json:"@type"`
+	} `json:"resources"`
+}
+
+type nugetV3SearchResponse struct {
+	TotalHits int `json:"totalHits"`
+	Data      []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+type nugetV3RegistrationIndex struct {
+	Items []nugetV3RegistrationPage `json:"items"`
+}
+
+type nugetV3RegistrationPage struct {
+	ID    string `json:"@id"`
+	Items []struct {
+		CatalogEntry struct {
+			Version   string `json:"version"`
+			Published string `json:"published"`
+		} `json:"catalogEntry"`
+	} `json:"items"`
+}
+
+func isNugetV3(progetConfig ProgetConfig) bool {
+	return progetConfig.Type == "nuget" && progetConfig.Protocol == "v3"
+}
+
+// nugetV3IndexURL returns the service index of the feed. ProGet serves it at
+// /nuget/<feed>/v3/index.json, other servers can set serviceIndex explicitly.
+func nugetV3IndexURL(progetConfig ProgetConfig) string {
+	if progetConfig.ServiceIndex != "" {
+		return progetConfig.ServiceIndex
+	}
+	return cleanURL(fmt.Sprintf("%s/nuget/%s/v3/index.json", progetConfig.URL, progetConfig.Feed))
+}
+
+// nugetV3Resource returns the base URL of the first resource from types found in the service index.
+func nugetV3Resource(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, types []string) (string, error) {
+	indexURL := nugetV3IndexURL(progetConfig)
+
+	nugetV3IndexCacheMu.Lock()
+	resources, ok := nugetV3IndexCache[indexURL]
+	nugetV3IndexCacheMu.Unlock()
+
+	if !ok {
+		client := &http.Client{
+			Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+		}
+		var index nugetV3ServiceIndex
+		err, _ := getJSON(ctx, client, indexURL, progetConfig, timeoutConfig, &index)
+		if err != nil {
+			return "", fmt.Errorf("failed to get service index %s: %w", indexURL, err)
+		}
+		resources = make(map[string]string)
+		for _, resource := range index.Resources {
+			if _, exists := resources[resource.Type]; !exists {
+				resources[resource.Type] = strings.TrimSuffix(resource.ID, "/")
+			}
+		}
+		nugetV3IndexCacheMu.Lock()
+		nugetV3IndexCache[indexURL] = resources
+		nugetV3IndexCacheMu.Unlock()
+	}
+
+	for _, resourceType := range types {
+		if resourceURL, ok := resources[resourceType]; ok {
+			return resourceURL, nil
+		}
+	}
+	return "", fmt.Errorf("service index %s has no %s resource", indexURL, types[0])
+}
+
+// getNugetV3Packages lists package ids through the search resource and their
+// versions through the flat container, falling back to registrations.
+func getNugetV3Packages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting packages via NuGet v3")
+	searchURL, err := nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3SearchQueryService)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	maxPages := progetConfig.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultNugetMaxPages
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for page := 0; ; page++ {
+		if page >= maxPages {
+			return nil, fmt.Errorf("nuget feed %s has more than %d search pages, increase maxPages", progetConfig.Feed, maxPages)
+		}
+		query := url.Values{}
+		query.Set("q", "")
+		query.Set("skip", strconv.Itoa(page*nugetV3SearchPageSize))
+		query.Set("take", strconv.Itoa(nugetV3SearchPageSize))
+		query.Set("prerelease", "true")
+		query.Set("semVerLevel", "2.0.0")
+
+		var search nugetV3SearchResponse
+		err, _ := getJSON(ctx, client, searchURL+"?"+query.Encode(), progetConfig, timeoutConfig, &search)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range search.Data {
+			if !seen[strings.ToLower(item.ID)] {
+				seen[strings.ToLower(item.ID)] = true
+				ids = append(ids, item.ID)
+			}
+		}
+		if len(search.Data) < nugetV3SearchPageSize {
+			break
+		}
+	}
+
+	packages := make([]Package, 0, len(ids))
+	for _, id := range ids {
+		versions, err := getNugetV3Versions(ctx, client, progetConfig, timeoutConfig, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		packages = append(packages, Package{
+			Group:    "",
+			Name:     id,
+			Versions: versions,
+		})
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// getNugetV3Versions returns the versions of a package, newest first.
+func getNugetV3Versions(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, id string) ([]string, error) {
+	var versions []string
+
+	baseURL, err := nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3PackageBaseAddress)
+	if err == nil {
+		var index struct {
+			Versions []string `json:"versions"`
+		}
+		err, statusCode := getJSON(ctx, client, fmt.Sprintf("%s/%s/index.json", baseURL, strings.ToLower(id)), progetConfig, timeoutConfig, &index)
+		if err == nil {
+			versions = index.Versions
+		} else if statusCode != http.StatusNotFound {
+			return nil, err
+		}
+	}
+
+	if versions == nil {
+		registrationsURL, err := nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3Registrations)
+		if err != nil {
+			return nil, err
+		}
+		var index nugetV3RegistrationIndex
+		err, _ = getJSON(ctx, client, fmt.Sprintf("%s/%s/index.json", registrationsURL, strings.ToLower(id)), progetConfig, timeoutConfig, &index)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range index.Items {
+			if page.Items == nil {
+				err, _ = getJSON(ctx, client, page.ID, progetConfig, timeoutConfig, &page)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, leaf := range page.Items {
+				versions = append(versions, leaf.CatalogEntry.Version)
+			}
+		}
+	}
+
+	// both resources list versions oldest first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// getNugetV3PublishDates reads the published field of the registration leaves.
+func getNugetV3PublishDates(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, id string) (map[string]time.Time, error) {
+	registrationsURL, err := nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3Registrations)
+	if err != nil {
+		return nil, err
+	}
+	var index nugetV3RegistrationIndex
+	err, _ = getJSON(ctx, client, fmt.Sprintf("%s/%s/index.json", registrationsURL, strings.ToLower(id)), progetConfig, timeoutConfig, &index)
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time)
+	for _, page := range index.Items {
+		if page.Items == nil {
+			err, _ = getJSON(ctx, client, page.ID, progetConfig, timeoutConfig, &page)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, leaf := range page.Items {
+			if published, ok := parsePublishTime(leaf.CatalogEntry.Published); ok {
+				dates[leaf.CatalogEntry.Version] = published
+			}
+		}
+	}
+	return dates, nil
+}
+
+func nugetV3DownloadURL(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, name, version string) (string, error) {
+	baseURL, err := nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3PackageBaseAddress)
+	if err != nil {
+		return "", err
+	}
+	id := strings.ToLower(name)
+	lowerVersion := strings.ToLower(version)
+	return fmt.Sprintf("%s/%s/%s/%s.%s.nupkg", baseURL, id, lowerVersion, id, lowerVersion), nil
+}
+
+func nugetV3UploadURL(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) (string, error) {
+	return nugetV3Resource(ctx, progetConfig, timeoutConfig, nugetV3PackagePublish)
+}
+
+// deleteNugetV3Package deletes a version through the PackagePublish resource,
+// the way nuget.exe delete does. Servers like nuget.org unlist it instead.
+func deleteNugetV3Package(ctx context.Context, progetConfig ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	publishURL, err := nugetV3UploadURL(ctx, progetConfig, timeoutConfig)
+	if err != nil {
+		return err, 0
+	}
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	deleteURL := fmt.Sprintf("%s/%s/%s", publishURL, url.PathEscape(pkg.Name), url.PathEscape(version))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err), 0
+	}
+	req.Header.Set("X-NuGet-ApiKey", progetConfig.APIKey)
+
+	resp, err := client.Do(req)
+	if resp == nil {
+		HttpRequestsTotal.With(prometheus.Labels{"action": "delete", "code": "deadline", "method": req.Method}).Inc()
+		return fmt.Errorf("failed to delete %s:%s: %w", pkg.Name, version, err), 0
+	}
+	resp.Body.Close()
+	HttpRequestsTotal.With(prometheus.Labels{"action": "delete", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return newRateLimitError(resp, fmt.Errorf("failed to delete %s:%s, rate limit exceeded", pkg.Name, version)), resp.StatusCode
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to delete %s:%s. Status: %d", pkg.Name, version, resp.StatusCode), resp.StatusCode
+	}
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Str("Action", "Delete").Msgf("Success delete: %s:%s", pkg.Name, version)
+	return nil, resp.StatusCode
+}
+
+// resetNugetV3IndexCache drops the cached service indexes, so every iteration
+// reads the resources the servers currently announce.
+func resetNugetV3IndexCache() {
+	nugetV3IndexCacheMu.Lock()
+	nugetV3IndexCache = make(map[string]map[string]string)
+	nugetV3IndexCacheMu.Unlock()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNugetVersionsMatchAcrossProtocols(t *testing.T) {
+	// an OData source lists the original versions, a v3 destination the normalized ones
+	source := []Package{{Name: "Lib", Versions: []string{"2.0.0-Beta", "1.0", "0.9.0.1"}}}
+	dest := []Package{{Name: "Lib", Versions: []string{"1.0.0", "0.9.0.1", "0.8.0"}}}
+	chain := SyncChain{Type: "nuget"}
+	config := &Config{ProceedPackageLimit: 10, ProceedPackageVersion: 10}
+
+	packages, err := getPackagesToSync(context.Background(), config, chain, source, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{{Name: "Lib", Versions: []string{"2.0.0-Beta"}}}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("packages to sync %v, want %v", packages, want)
+	}
+
+	deleted, _ := getPackagesToMirror(nugetDriver{}, source, dest)
+	want = []Package{{Name: "Lib", Versions: []string{"0.8.0"}}}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("packages to mirror %v, want %v", deleted, want)
+	}
+}
+
+// nugetPagingServer serves 205 versions of one package: the first page links
+// the second, the second is full without a link, the third is the last.
+func nugetPagingServer(t *testing.T) (*httptest.Server, *[]string) {
+	// the pages are requested one after another
+	var requests []string
+	entries := func(w http.ResponseWriter, from, to int, next string) {
+		fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom">`)
+		for i := from; i < to; i++ {
+			fmt.Fprintf(w, `<entry><id>http://proget/nuget/feed/Packages(Id='Lib',Version='1.0.%d')</id></entry>`, i)
+		}
+		if next != "" {
+			fmt.Fprintf(w, `<link rel="next" href="%s"/>`, next)
+		}
+		fmt.Fprint(w, `</feed>`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path != "/nuget/feed/packages":
+			w.WriteHeader(http.StatusNotFound)
+		case query.Get("$skiptoken") == "2":
+			entries(w, 100, 200, "")
+		case query.Get("$skip") == "0":
+			entries(w, 0, 100, "packages?$skiptoken=2")
+		case query.Get("$skip") == "200" && query.Get("$top") == "100":
+			entries(w, 200, 205, "")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGetNugetPackagesPaging(t *testing.T) {
+	server, requests := nugetPagingServer(t)
+	feed := ProgetConfig{URL: server.URL, Feed: "feed", Type: "nuget"}
+	timeout := TimeoutConfig{IterationTimeout: 5, MaxRetries: 1}
+
+	packages, err := getNugetPackages(context.Background(), feed, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || len(packages[0].Versions) != 205 {
+		t.Fatalf("listed %d packages, want Lib with 205 versions", len(packages))
+	}
+	// the next link is followed, the full page without one falls back to $skip/$top
+	want := []string{"%24skip=0&%24top=100", "$skiptoken=2", "%24skip=200&%24top=100"}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Errorf("requested %v, want %v", *requests, want)
+	}
+
+	// more pages than maxPages fail instead of returning a partial list
+	feed.MaxPages = 2
+	*requests = nil
+	if _, err := getNugetPackages(context.Background(), feed, timeout); err == nil || !strings.Contains(err.Error(), "maxPages") {
+		t.Errorf("getNugetPackages with maxPages 2 returned %v, want the page limit error", err)
+	}
+	if len(*requests) != 2 {
+		t.Errorf("requested %v, want the 2 allowed pages", *requests)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+)
+
+// Orders of the packages to sync. The packages matching the priority patterns
+// go first in the order of the patterns, the order sorts the rest and ties.
+const (
+	// orderNewest syncs the newest versions of every package first.
+	orderNewest = "newest"
+	// orderOldestMissing syncs the oldest missing versions first, to fill the history.
+	orderOldestMissing = "oldest-missing"
+	// orderSmallest syncs the packages with the fewest missing versions first.
+	orderSmallest = "smallest"
+
+	defaultOrder = orderNewest
+)
+
+// sortPackagesToSync orders the packages and their versions, so the limits
+// pick the same versions in every iteration.
+func sortPackagesToSync(config *Config, driver FeedDriver, packages []Package) {
+	for _, pkg := range packages {
+		versions := pkg.Versions
+		sort.SliceStable(versions, func(i, j int) bool {
+			if config.Order == orderOldestMissing {
+				return driver.CompareVersions(versions[i], versions[j]) < 0
+			}
+			return driver.CompareVersions(versions[i], versions[j]) > 0
+		})
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool {
+		pi, pj := packagePriority(config.Priority, packages[i]), packagePriority(config.Priority, packages[j])
+		if pi != pj {
+			return pi < pj
+		}
+		if config.Order == orderSmallest && len(packages[i].Versions) != len(packages[j].Versions) {
+			return len(packages[i].Versions) < len(packages[j].Versions)
+		}
+		if packages[i].Group != packages[j].Group {
+			return packages[i].Group < packages[j].Group
+		}
+		return packages[i].Name < packages[j].Name
+	})
+}
+
+// packagePriority returns the index of the first priority pattern matching
+// "group/name" or the name, packages matching no pattern go last.
+func packagePriority(priority []string, pkg Package) int {
+	fullName := pkg.Name
+	if pkg.Group != "" {
+		fullName = pkg.Group + "/" + pkg.Name
+	}
+	for i, pattern := range priority {
+		if matchPattern(pattern, fullName) || matchPattern(pattern, pkg.Name) {
+			return i
+		}
+	}
+	return len(priority)
+}
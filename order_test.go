@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func orderTestPackages() []Package {
+	return []Package{
+		{Group: "tools", Name: "cli", Versions: []string{"1.0.0", "1.10.0", "1.2.0"}},
+		{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+		{Group: "libs", Name: "app", Versions: []string{"0.2.0", "0.1.0"}},
+		{Group: "", Name: "base", Versions: []string{"3.0.0-rc.1", "3.0.0", "2.9.0"}},
+	}
+}
+
+func TestSortPackagesToSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		priority []string
+		want     []Package
+	}{
+		{
+			name:  "newest",
+			order: orderNewest,
+			want: []Package{
+				{Group: "", Name: "base", Versions: []string{"3.0.0", "3.0.0-rc.1", "2.9.0"}},
+				{Group: "libs", Name: "app", Versions: []string{"0.2.0", "0.1.0"}},
+				{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+				{Group: "tools", Name: "cli", Versions: []string{"1.10.0", "1.2.0", "1.0.0"}},
+			},
+		},
+		{
+			name:  "oldest missing",
+			order: orderOldestMissing,
+			want: []Package{
+				{Group: "", Name: "base", Versions: []string{"2.9.0", "3.0.0-rc.1", "3.0.0"}},
+				{Group: "libs", Name: "app", Versions: []string{"0.1.0", "0.2.0"}},
+				{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+				{Group: "tools", Name: "cli", Versions: []string{"1.0.0", "1.2.0", "1.10.0"}},
+			},
+		},
+		{
+			name:  "smallest",
+			order: orderSmallest,
+			want: []Package{
+				{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+				{Group: "libs", Name: "app", Versions: []string{"0.2.0", "0.1.0"}},
+				{Group: "", Name: "base", Versions: []string{"3.0.0", "3.0.0-rc.1", "2.9.0"}},
+				{Group: "tools", Name: "cli", Versions: []string{"1.10.0", "1.2.0", "1.0.0"}},
+			},
+		},
+		{
+			// the patterns go first in their order, group/name or the name alone matches
+			name:     "priority",
+			order:    orderSmallest,
+			priority: []string{"tools/*", "base"},
+			want: []Package{
+				{Group: "tools", Name: "cli", Versions: []string{"1.10.0", "1.2.0", "1.0.0"}},
+				{Group: "", Name: "base", Versions: []string{"3.0.0", "3.0.0-rc.1", "2.9.0"}},
+				{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+				{Group: "libs", Name: "app", Versions: []string{"0.2.0", "0.1.0"}},
+			},
+		},
+		{
+			name:     "priority by name",
+			order:    orderNewest,
+			priority: []string{"core"},
+			want: []Package{
+				{Group: "libs", Name: "core", Versions: []string{"2.0.0"}},
+				{Group: "", Name: "base", Versions: []string{"3.0.0", "3.0.0-rc.1", "2.9.0"}},
+				{Group: "libs", Name: "app", Versions: []string{"0.2.0", "0.1.0"}},
+				{Group: "tools", Name: "cli", Versions: []string{"1.10.0", "1.2.0", "1.0.0"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages := orderTestPackages()
+			sortPackagesToSync(&Config{Order: tt.order, Priority: tt.priority}, npmDriver{}, packages)
+			if !reflect.DeepEqual(packages, tt.want) {
+				t.Errorf("sorted\n%v\nwant\n%v", packages, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortPackagesToSyncStable(t *testing.T) {
+	// the order does not depend on the order of the listing, so the limits pick the same versions
+	first := orderTestPackages()
+	second := orderTestPackages()
+	for i, j := 0, len(second)-1; i < j; i, j = i+1, j-1 {
+		second[i], second[j] = second[j], second[i]
+	}
+	config := &Config{Order: orderSmallest}
+	sortPackagesToSync(config, npmDriver{}, first)
+	sortPackagesToSync(config, npmDriver{}, second)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("orders differ\n%v\n%v", first, second)
+	}
+}
+
+func TestLimitPackagesAfterOrder(t *testing.T) {
+	config := &Config{Order: orderOldestMissing, Priority: []string{"tools/*"}, ProceedPackageLimit: 2, ProceedPackageVersion: 2}
+	packages := orderTestPackages()
+	sortPackagesToSync(config, npmDriver{}, packages)
+
+	limited := limitPackages(config, packages)
+	want := []Package{
+		{Group: "tools", Name: "cli", Versions: []string{"1.0.0", "1.2.0"}},
+		{Group: "", Name: "base", Versions: []string{"2.9.0", "3.0.0-rc.1"}},
+	}
+	if !reflect.DeepEqual(limited, want) {
+		t.Errorf("limited %v, want %v", limited, want)
+	}
+	// the versions of the caller are not cut
+	if len(packages[0].Versions) != 3 {
+		t.Errorf("limitPackages changed the sorted packages: %v", packages[0])
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		order    string
+		priority []string
+		errors   int
+	}{
+		{"", nil, 0},
+		{orderNewest, []string{"tools/*"}, 0},
+		{orderOldestMissing, nil, 0},
+		{orderSmallest, nil, 0},
+		{"largest", nil, 1},
+		{orderNewest, []string{"[tools"}, 1},
+	}
+	for _, tt := range tests {
+		if got := validateOrder(tt.order, tt.priority, "config"); len(got) != tt.errors {
+			t.Errorf("validateOrder(%q, %v) = %v, want %d errors", tt.order, tt.priority, got, tt.errors)
+		}
+	}
+}
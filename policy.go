@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+)
+
+// retentionDecision is the verdict of the retention policy for one version.
+type retentionDecision struct {
+	Version string
+	Keep    bool
+	Reason  string
+}
+
+// policyFor returns the policy of the first override matching the package, or the global one.
+func (r RetentionConfig) policyFor(pkg Package) RetentionPolicy {
+	for _, override := range r.Overrides {
+		if matchPattern(override.Group, pkg.Group) && matchPattern(override.Name, pkg.Name) {
+			return override.RetentionPolicy
+		}
+	}
+	return r.RetentionPolicy
+}
+
+// matchPattern matches a path.Match pattern, an empty pattern matches everything.
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// evaluateRetention decides which versions the policy keeps, versions are
+// ordered newest first. A version is kept when any rule keeps it. The age rule
+// keeps versions without a publish date in published, so a nil map keeps all
+// versions by age.
+func evaluateRetention(policy RetentionPolicy, driver FeedDriver, versions []string, published map[string]time.Time, now time.Time) []retentionDecision {
+	var keepRegexp *regexp.Regexp
+	if policy.KeepRegex != "" {
+		// the expression is checked by validateConfig
+		keepRegexp = regexp.MustCompile(policy.KeepRegex)
+	}
+
+	decisions := make([]retentionDecision, 0, len(versions))
+	stable, prerelease := 0, 0
+	for i, version := range versions {
+		isPrerelease := driver.IsPrerelease(version)
+		publishedAt, hasDate := published[version]
+
+		decision := retentionDecision{Version: version, Keep: true}
+		switch {
+		case i < policy.VersionLimit:
+			decision.Reason = fmt.Sprintf("within versionLimit %d", policy.VersionLimit)
+		case !isPrerelease && stable < policy.KeepStable:
+			decision.Reason = fmt.Sprintf("one of the last %d stable versions", policy.KeepStable)
+		case isPrerelease && prerelease < policy.KeepPrerelease:
+			decision.Reason = fmt.Sprintf("one of the last %d prerelease versions", policy.KeepPrerelease)
+		case keepRegexp != nil && keepRegexp.MatchString(version):
+			decision.Reason = fmt.Sprintf("matches keepRegex %s", policy.KeepRegex)
+		case policy.KeepDays > 0 && !hasDate:
+			decision.Reason = "publish date unknown, kept by keepDays"
+		case policy.KeepDays > 0 && now.Sub(publishedAt) < time.Duration(policy.KeepDays)*24*time.Hour:
+			decision.Reason = fmt.Sprintf("published %s, within keepDays %d", publishedAt.Format(time.RFC3339), policy.KeepDays)
+		default:
+			decision.Keep = false
+			decision.Reason = "not kept by any rule"
+			if hasDate {
+				decision.Reason = fmt.Sprintf("published %s, not kept by any rule", publishedAt.Format(time.RFC3339))
+			}
+		}
+		decisions = append(decisions, decision)
+
+		if isPrerelease {
+			prerelease++
+		} else {
+			stable++
+		}
+	}
+	return decisions
+}
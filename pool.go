@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Default concurrency.workers, the other limits default to the number of workers.
+const defaultWorkers = 8
+
+// transferPool runs the version transfers of all chains on a fixed number of
+// workers. Chains submit to one bounded queue, a submit blocks while the
+// queue is full, the chain is at its concurrency or a host it transfers from
+// or to is at perHost, so a chain with many versions waits for its own slots
+// instead of taking the workers of the other chains.
+type transferPool struct {
+	jobs    chan transferJob
+	perHost int
+	workers sync.WaitGroup
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+type transferJob struct {
+	ctx   context.Context
+	run   func(ctx context.Context) error
+	group *transferGroup
+	hosts []string
+}
+
+// transferGroup is the transfers of one chain submitted to the pool.
+type transferGroup struct {
+	pool  *transferPool
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func newTransferPool(config ConcurrencyConfig) *transferPool {
+	pool := &transferPool{
+		jobs:    make(chan transferJob, config.QueueSize),
+		perHost: config.PerHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		pool.workers.Add(1)
+		go pool.work()
+	}
+	return pool
+}
+
+func (p *transferPool) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		err := job.run(job.ctx)
+		p.release(job.hosts)
+		job.group.done(err)
+	}
+}
+
+// close stops the workers after the queued transfers finish.
+func (p *transferPool) close() {
+	close(p.jobs)
+	p.workers.Wait()
+}
+
+// group returns a group running at most concurrency transfers at once.
+func (p *transferPool) group(concurrency int) *transferGroup {
+	return &transferGroup{pool: p, slots: make(chan struct{}, concurrency)}
+}
+
+func (p *transferPool) hostSlots(host string) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slots, ok := p.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, p.perHost)
+		p.hosts[host] = slots
+	}
+	return slots
+}
+
+// acquire takes a slot of every host. The hosts are sorted, so transfers
+// waiting for the same hosts cannot hold each other's slots.
+func (p *transferPool) acquire(ctx context.Context, hosts []string) error {
+	for i, host := range hosts {
+		select {
+		case p.hostSlots(host) <- struct{}{}:
+		case <-ctx.Done():
+			p.release(hosts[:i])
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (p *transferPool) release(hosts []string) {
+	for _, host := range hosts {
+		<-p.hostSlots(host)
+	}
+}
+
+// submit queues the transfer, blocking until the chain, its hosts and the
+// queue have room. An error is returned only when ctx is done while waiting.
+func (g *transferGroup) submit(ctx context.Context, feeds []ProgetConfig, run func(ctx context.Context) error) error {
+	select {
+	case g.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	hosts := feedHosts(feeds)
+	err := g.pool.acquire(ctx, hosts)
+	if err != nil {
+		<-g.slots
+		return err
+	}
+
+	g.wg.Add(1)
+	select {
+	case g.pool.jobs <- transferJob{ctx: ctx, run: run, group: g, hosts: hosts}:
+		return nil
+	case <-ctx.Done():
+		g.pool.release(hosts)
+		g.done(nil)
+		return ctx.Err()
+	}
+}
+
+func (g *transferGroup) done(err error) {
+	if err != nil {
+		g.mu.Lock()
+		if g.err == nil {
+			g.err = err
+		}
+		g.mu.Unlock()
+	}
+	<-g.slots
+	g.wg.Done()
+}
+
+// wait waits for the submitted transfers and returns the first failed one.
+func (g *transferGroup) wait() error {
+	g.wg.Wait()
+	return g.err
+}
+
+// feedHosts returns the sorted distinct hosts of the feeds.
+func feedHosts(feeds []ProgetConfig) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, feed := range feeds {
+		host := feed.URL
+		if parsedURL, err := url.Parse(feed.URL); err == nil && parsedURL.Host != "" {
+			host = parsedURL.Host
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
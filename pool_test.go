@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingTransfers runs transfers that wait until released.
+type blockingTransfers struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingTransfers() *blockingTransfers {
+	return &blockingTransfers{started: make(chan string, 16), release: make(chan struct{})}
+}
+
+func (b *blockingTransfers) run(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		b.started <- name
+		<-b.release
+		return nil
+	}
+}
+
+// waitStarted waits for n transfers to start and returns their names.
+func (b *blockingTransfers) waitStarted(t *testing.T, n int) []string {
+	t.Helper()
+	var names []string
+	for i := 0; i < n; i++ {
+		select {
+		case name := <-b.started:
+			names = append(names, name)
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d transfers started", len(names), n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// submitBlocked checks that the submit waits, it gives up when ctx is done.
+func submitBlocked(t *testing.T, group *transferGroup, feeds []ProgetConfig, run func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := group.submit(ctx, feeds, run); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("submit returned %v, want it to wait", err)
+	}
+}
+
+func feedsOf(urls ...string) []ProgetConfig {
+	var feeds []ProgetConfig
+	for _, url := range urls {
+		feeds = append(feeds, ProgetConfig{URL: url, Feed: "feed"})
+	}
+	return feeds
+}
+
+func TestTransferPoolPerChain(t *testing.T) {
+	pool := newTransferPool(ConcurrencyConfig{Workers: 8, PerHost: 8, QueueSize: 8})
+	defer pool.close()
+	transfers := newBlockingTransfers()
+	first, second := pool.group(2), pool.group(2)
+	firstFeeds, secondFeeds := feedsOf("https://first.example.com"), feedsOf("https://second.example.com")
+
+	for i := 0; i < 2; i++ {
+		if err := first.submit(context.Background(), firstFeeds, transfers.run("first")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first chain is at its limit
+	submitBlocked(t, first, firstFeeds, transfers.run("first"))
+
+	// it does not keep the other chain from the workers
+	for i := 0; i < 2; i++ {
+		if err := second.submit(context.Background(), secondFeeds, transfers.run("second")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"first", "first", "second", "second"}
+	if got := transfers.waitStarted(t, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("started %v, want %v", got, want)
+	}
+
+	close(transfers.release)
+	if err := first.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.slots) != 0 || len(second.slots) != 0 {
+		t.Errorf("the chains hold %d and %d slots after the transfers", len(first.slots), len(second.slots))
+	}
+}
+
+func TestTransferPoolPerHost(t *testing.T) {
+	pool := newTransferPool(ConcurrencyConfig{Workers: 8, PerHost: 2, QueueSize: 8})
+	defer pool.close()
+	transfers := newBlockingTransfers()
+	first, second := pool.group(8), pool.group(8)
+
+	// both chains transfer to the shared host, each from its own one
+	shared := "https://shared.example.com:8443/feed"
+	firstFeeds := feedsOf("https://a.example.com/feed", shared, "https://shared.example.com:8443/other")
+	if err := first.submit(context.Background(), firstFeeds, transfers.run("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.submit(context.Background(), firstFeeds, transfers.run("first")); err != nil {
+		t.Fatal(err)
+	}
+	transfers.waitStarted(t, 2)
+
+	// the shared host is at perHost, a transfer to another host still runs
+	submitBlocked(t, second, feedsOf("https://b.example.com/feed", shared), transfers.run("second"))
+	if err := second.submit(context.Background(), feedsOf("https://b.example.com/feed", "https://c.example.com"), transfers.run("second")); err != nil {
+		t.Fatal(err)
+	}
+	transfers.waitStarted(t, 1)
+
+	close(transfers.release)
+	if err := first.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.wait(); err != nil {
+		t.Fatal(err)
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for host, slots := range pool.hosts {
+		if len(slots) != 0 {
+			t.Errorf("host %s holds %d slots after the transfers", host, len(slots))
+		}
+	}
+}
+
+func TestTransferPoolBackPressure(t *testing.T) {
+	pool := newTransferPool(ConcurrencyConfig{Workers: 1, PerHost: 8, QueueSize: 1})
+	defer pool.close()
+	group := pool.group(8)
+	feeds := feedsOf("https://a.example.com")
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	blocking := func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+
+	// the worker runs the first transfer, the second fills the queue
+	if err := group.submit(context.Background(), feeds, blocking); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := group.submit(context.Background(), feeds, blocking); err != nil {
+		t.Fatal(err)
+	}
+
+	// the third blocks until ctx is done and leaves no slots taken
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := group.submit(ctx, feeds, blocking)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("submit to a full queue returned %v, want the deadline", err)
+	}
+	if len(group.slots) != 2 {
+		t.Errorf("the chain holds %d slots, want the 2 submitted transfers", len(group.slots))
+	}
+	if slots := pool.hostSlots("a.example.com"); len(slots) != 2 {
+		t.Errorf("the host holds %d slots, want the 2 submitted transfers", len(slots))
+	}
+
+	// once the worker is free, a blocked submit goes through
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- group.submit(context.Background(), feeds, blocking)
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("submit did not wait for the queue: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if err := group.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 2 {
+		t.Errorf("%d more transfers started, want 2", len(started))
+	}
+}
+
+func TestTransferGroupError(t *testing.T) {
+	pool := newTransferPool(ConcurrencyConfig{Workers: 1, PerHost: 1, QueueSize: 4})
+	defer pool.close()
+	group := pool.group(4)
+	feeds := feedsOf("https://a.example.com")
+
+	var mu sync.Mutex
+	var ran []int
+	for i := 0; i < 3; i++ {
+		i := i
+		err := group.submit(context.Background(), feeds, func(ctx context.Context) error {
+			mu.Lock()
+			ran = append(ran, i)
+			mu.Unlock()
+			if i > 0 {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := group.wait()
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("wait returned %v, want the failed transfer", err)
+	}
+	// a failed transfer does not stop the ones already submitted
+	if !reflect.DeepEqual(ran, []int{0, 1, 2}) {
+		t.Errorf("ran %v, want all three transfers", ran)
+	}
+}
+
+func TestFeedHosts(t *testing.T) {
+	feeds := feedsOf("https://b.example.com/feed", "https://a.example.com:8443", "https://b.example.com/other", "local")
+	want := []string{"a.example.com:8443", "b.example.com", "local"}
+	if got := feedHosts(feeds); !reflect.DeepEqual(got, want) {
+		t.Errorf("feedHosts = %v, want %v", got, want)
+	}
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -21,6 +22,20 @@ import (
 )
 
 func getPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	driver, err := getFeedDriver(progetConfig.Type)
+	if err != nil {
+		return nil, err
+	}
+	packages, err := driver.List(ctx, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(driver, packages)
+	return packages, nil
+}
+
+// getProgetPackages lists upack and asset feeds through the ProGet JSON API.
+func getProgetPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
 	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting packages")
 	var (
 		url      string
@@ -72,13 +87,6 @@ func getPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig T
 					time.Sleep(5 * time.Duration(attempt) * time.Second)
 					continue
 				}
-			case "nuget":
-				packages, err = decodeXML(bodyString)
-				if err != nil {
-					log.Error().Err(err).Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Str("feed", progetConfig.Feed).Msgf("error decoding package list")
-					time.Sleep(5 * time.Duration(attempt) * time.Second)
-					continue
-				}
 			case "asset":
 				err = json.NewDecoder(strings.NewReader(bodyString)).Decode(&assets)
 				if err != nil {
@@ -115,23 +123,81 @@ func getPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig T
 	return nil, fmt.Errorf("failed to get package after %d attempts", timeoutConfig.MaxRetries)
 }
 
-func getPackagesToSync(config *Config, chain SyncChain, sourcePackages, destPackages []Package) ([]Package, error) {
+// getPackagesToSync returns the source versions missing on the destination
+// that the chain filter and the destination retention policy keep. Versions
+// retention would delete are not synced, for the age rule the publish dates
+// come from the source, as a synced version gets a new one on the destination.
+func getPackagesToSync(ctx context.Context, config *Config, chain SyncChain, sourcePackages, destPackages []Package) ([]Package, error) {
 	log.Debug().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msg("Work with packages array")
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcePackages, filteredPackages, filteredVersions := filterPackages(chain.Filter, driver, sourcePackages)
+	if filteredPackages > 0 || filteredVersions > 0 {
+		log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Filtered out %d packages and %d versions", filteredPackages, filteredVersions)
+	}
+	FilteredTotal.With(prometheus.Labels{"feed": chain.Destination.Feed, "kind": "package"}).Set(float64(filteredPackages))
+	FilteredTotal.With(prometheus.Labels{"feed": chain.Destination.Feed, "kind": "version"}).Set(float64(filteredVersions))
+
+	destPackageMap := make(map[string]map[string]bool)
+	for _, pkg := range destPackages {
+		key := fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)
+		if destPackageMap[key] == nil {
+			destPackageMap[key] = make(map[string]bool)
+		}
+		for _, version := range pkg.Versions {
+			destPackageMap[key][versionKey(driver, version)] = true
+		}
+	}
+
+	now := time.Now()
+	// asset retention works on directories, the files it deletes are not synced
+	var assetsDeleted map[string]bool
+	if config.Retention.Enabled && chain.Type == "asset" && lacksPackages(driver, destPackageMap, sourcePackages) {
+		assetsDeleted, err = sourceAssetsDeleted(ctx, config, chain, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply asset retention to source files: %w", err)
+		}
+	}
+
 	sourcePackageMap := make(map[string]map[string]bool)
 	for _, pkg := range sourcePackages {
 		key := fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)
 		if sourcePackageMap[key] == nil {
 			sourcePackageMap[key] = make(map[string]bool)
 		}
+		var decisions []retentionDecision
+		// asset files have no versions, their retention works on directories
+		retentionEnabled := config.Retention.Enabled && chain.Type != "asset"
+		if retentionEnabled {
+			remapped := chain.remap(pkg)
+			policy := config.Retention.policyFor(remapped)
+			var published map[string]time.Time
+			if policy.KeepDays > 0 && lacksVersions(driver, destPackageMap[fmt.Sprintf("%s:%s", remapped.Group, remapped.Name)], pkg.Versions) {
+				published, err = sourcePublishDates(ctx, config, chain, driver, pkg)
+				if err != nil {
+					log.Error().Err(err).Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Failed to get publish dates of %s/%s, skip sync", pkg.Group, pkg.Name)
+					continue
+				}
+			}
+			decisions = evaluateRetention(policy, driver, pkg.Versions, published, now)
+		}
 		for i, version := range pkg.Versions {
-			if !config.Retention.Enabled {
+			if assetsDeleted[pkg.Name] {
+				if config.Retention.DryRun {
+					log.Warn().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("%s is not kept by retention policy, will be processed (dry-run is on)", pkg.Name)
+				}
+				sourcePackageMap[key][version] = config.Retention.DryRun
+			} else if !retentionEnabled {
 				sourcePackageMap[key][version] = true
 			} else {
-				if i <= config.Retention.VersionLimit-1 {
+				if decisions[i].Keep {
 					sourcePackageMap[key][version] = true
 				} else {
-					if !config.Retention.DryRun {
-						log.Warn().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("%s:%s exceedes version limit, will be processed (dry-run is on)", key, version)
+					if config.Retention.DryRun {
+						log.Warn().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("%s:%s is not kept by retention policy, will be processed (dry-run is on)", key, version)
 						sourcePackageMap[key][version] = true
 					} else {
 						sourcePackageMap[key][version] = false
@@ -141,22 +207,13 @@ func getPackagesToSync(config *Config, chain SyncChain, sourcePackages, destPack
 		}
 	}
 
-	destPackageMap := make(map[string]map[string]bool)
-	for _, pkg := range destPackages {
-		key := fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)
-		if destPackageMap[key] == nil {
-			destPackageMap[key] = make(map[string]bool)
-		}
-		for _, version := range pkg.Versions {
-			destPackageMap[key][version] = true
-		}
-	}
-
 	packagesToSyncMap := make(map[string]*Package)
 	for _, pkg := range sourcePackages {
+		remapped := chain.remap(pkg)
+		destKey := fmt.Sprintf("%s:%s", remapped.Group, remapped.Name)
 		for _, version := range pkg.Versions {
 			key := fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)
-			if !destPackageMap[key][version] {
+			if !destPackageMap[destKey][versionKey(driver, version)] {
 				if sourcePackageMap[key][version] {
 					log.Printf("%s:%s:%s not found.", pkg.Group, pkg.Name, version)
 					if existingPkg, exists := packagesToSyncMap[key]; exists {
@@ -177,102 +234,188 @@ func getPackagesToSync(config *Config, chain SyncChain, sourcePackages, destPack
 	for _, pkg := range packagesToSyncMap {
 		packagesToSync = append(packagesToSync, *pkg)
 	}
+	sortPackagesToSync(config, driver, packagesToSync)
 
 	return packagesToSync, nil
 }
 
-func downloadAndUploadPackage(ctx context.Context, config *Config, chain SyncChain, pkg Package, version string, savePath string) error {
-	srcParsedURL, err := url.Parse(chain.Source.URL)
-	if err != nil {
-		return fmt.Errorf("failed to parse url: %s", err)
+// lacksVersions reports whether some of versions is not in destVersions, which holds version keys.
+func lacksVersions(driver FeedDriver, destVersions map[string]bool, versions []string) bool {
+	for _, version := range versions {
+		if !destVersions[versionKey(driver, version)] {
+			return true
+		}
 	}
-	srcParseURL := srcParsedURL.Scheme + "://" + srcParsedURL.Host
+	return false
+}
 
-	dstParsedURL, err := url.Parse(chain.Destination.URL)
-	if err != nil {
-		return fmt.Errorf("failed to parse url: %s", err)
+// lacksPackages reports whether some source version is missing in destPackageMap.
+func lacksPackages(driver FeedDriver, destPackageMap map[string]map[string]bool, sourcePackages []Package) bool {
+	for _, pkg := range sourcePackages {
+		if lacksVersions(driver, destPackageMap[fmt.Sprintf("%s:%s", pkg.Group, pkg.Name)], pkg.Versions) {
+			return true
+		}
 	}
-	dstParseURL := dstParsedURL.Scheme + "://" + dstParsedURL.Host
-	var (
-		downloadURL,
-		uploadURL,
-		filePath string
-	)
-
-	log.Debug().Str("url", chain.Source.URL).Str("feed", chain.Source.Feed).Msgf("Switch to choose urls. case: %s", chain.Destination.Type)
+	return false
+}
 
-	switch chain.Type {
-	case "upack":
-		downloadURL = cleanURL(fmt.Sprintf("%s/%s/%s/download/%s/%s/%s", chain.Source.URL, chain.Type, chain.Source.Feed, pkg.Group, pkg.Name, version))
-		uploadURL = cleanURL(fmt.Sprintf("%s/%s/%s/upload", chain.Destination.URL, chain.Type, chain.Destination.Feed))
-		filePath = filepath.Join(savePath, fmt.Sprintf("%s.%s.upack", pkg.Name, version))
-	case "nuget":
-		downloadURL = cleanURL(fmt.Sprintf("%s/%s/%s/package/%s/%s", chain.Source.URL, chain.Type, chain.Source.Feed, pkg.Name, version))
-		uploadURL = cleanURL(fmt.Sprintf("%s/%s/%s/upload", chain.Destination.URL, chain.Type, chain.Destination.Feed))
-		filePath = filepath.Join(savePath, fmt.Sprintf("%s.%s.nupkg", pkg.Name, version))
-	case "asset":
-		downloadURL = cleanURL(fmt.Sprintf("%s/endpoints/%s/content/%s", chain.Source.URL, chain.Source.Feed, pkg.Name))
-		uploadURL = cleanURL(fmt.Sprintf("%s/endpoints/%s/content/%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name))
-		filePath = filepath.Join(savePath, pkg.Name)
+// sourceAssetsDeleted returns the source files the asset retention policy
+// would delete from the destination. A file listed by several sources gets
+// the modification date of the first one.
+func sourceAssetsDeleted(ctx context.Context, config *Config, chain SyncChain, now time.Time) (map[string]bool, error) {
+	sources := chain.Sources
+	if len(sources) == 0 {
+		sources = []ProgetConfig{chain.Source}
 	}
-
-	err = os.MkdirAll(savePath, os.ModePerm)
-	if err != nil {
-		log.Error().Err(err).Msgf("Failed to create dir %s", savePath)
+	var assets []Asset
+	listed := make(map[string]bool)
+	for _, source := range sources {
+		sourceAssets, err := getAssets(ctx, source, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, asset := range sourceAssets {
+			if !listed[asset.Name] {
+				listed[asset.Name] = true
+				assets = append(assets, asset)
+			}
+		}
 	}
 
-	for attempt := 1; attempt <= config.Timeout.MaxRetries; attempt++ {
-		log.Info().Str("url", srcParseURL).Str("feed", chain.Source.Feed).Str("Action", "Download").Msgf("Attempt %d download package %s", attempt, pkg.Name)
-		err, statusCode := downloadFile(ctx, downloadURL, filePath, chain.Source, config.Timeout)
-		switch statusCode {
-		case 401:
-			return fmt.Errorf("failed to download %s, check apiKey permisson (Download)", filepath.Base(filePath))
+	deleted := make(map[string]bool)
+	for _, decision := range evaluateAssetRetention(config.Retention, assets, now) {
+		if !decision.Keep {
+			deleted[decision.Name] = true
 		}
+	}
+	return deleted, nil
+}
+
+// sourcePublishDates returns the publish dates of the package on the sources
+// of the chain, a version listed by several sources gets the date of the
+// first one. Drivers without publish dates return nil.
+func sourcePublishDates(ctx context.Context, config *Config, chain SyncChain, driver FeedDriver, pkg Package) (map[string]time.Time, error) {
+	dater, ok := driver.(publishDater)
+	if !ok {
+		return nil, nil
+	}
+	sources := chain.Sources
+	if len(sources) == 0 {
+		sources = []ProgetConfig{chain.Source}
+	}
+	published := make(map[string]time.Time)
+	for _, source := range sources {
+		dates, err := dater.PublishDates(ctx, source, pkg, config.Timeout)
 		if err != nil {
-			log.Error().Err(err).Str("url", srcParseURL).Str("feed", chain.Source.Feed).Str("Action", "Download").Msgf("Attempt: %d failed", attempt)
-			time.Sleep(5 * time.Duration(attempt) * time.Second)
-		} else {
-			break
+			return nil, err
 		}
-		if attempt == config.Timeout.MaxRetries {
-			return fmt.Errorf("failed to download %s", filepath.Base(filePath))
+		for version, date := range dates {
+			if _, exists := published[version]; !exists {
+				published[version] = date
+			}
 		}
 	}
+	return published, nil
+}
 
-	for attempt := 1; attempt <= config.Timeout.MaxRetries; attempt++ {
-		log.Info().Str("url", dstParseURL).Str("feed", chain.Destination.Feed).Str("Action", "Upload").Msgf("Attempt %d upload file %s", attempt, pkg.Name)
-		err, statusCode := uploadFile(ctx, uploadURL, filePath, chain.Destination, config.Timeout)
-		switch statusCode {
-		case 401:
-			return fmt.Errorf("failed to upload %s, check apiKey permisson (add)", filepath.Base(filePath))
+// getPackagesToMirror returns the destination versions that are absent on the
+// source and the share of all destination versions they make up, in percent.
+func getPackagesToMirror(driver FeedDriver, sourcePackages, destPackages []Package) ([]Package, float64) {
+	sourceVersions := make(map[string]bool)
+	for _, pkg := range sourcePackages {
+		for _, version := range pkg.Versions {
+			sourceVersions[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, versionKey(driver, version))] = true
 		}
-		if err != nil {
-			log.Error().Str("url", srcParseURL).Str("feed", chain.Destination.Feed).Str("Action", "Upload").Err(err).Msgf("Attempt: %d failed", attempt)
-			time.Sleep(5 * time.Duration(attempt) * time.Second)
-		} else {
-			break
+	}
+
+	var (
+		packages []Package
+		total    int
+		deleted  int
+	)
+	for _, pkg := range destPackages {
+		var versions []string
+		for _, version := range pkg.Versions {
+			total++
+			if !sourceVersions[fmt.Sprintf("%s:%s:%s", pkg.Group, pkg.Name, versionKey(driver, version))] {
+				versions = append(versions, version)
+			}
 		}
-		if attempt == config.Timeout.MaxRetries {
-			return fmt.Errorf("failed to upload %s", filepath.Base(filePath))
+		if len(versions) > 0 {
+			deleted += len(versions)
+			packages = append(packages, Package{Group: pkg.Group, Name: pkg.Name, Versions: versions})
 		}
 	}
-	return checkPackageHash(ctx, chain, pkg, version, config.Timeout)
+	if total == 0 {
+		return packages, 0
+	}
+	return packages, float64(deleted) * 100 / float64(total)
+}
+
+func downloadAndUploadPackage(ctx context.Context, config *Config, chain SyncChain, queue *deleteQueue, sources versionSources, pkg Package, version string, savePath string) error {
+	chain = sources.chainFor(chain, pkg, version)
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
+
+	dir, err := createTransferDir(savePath, pkg, version)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := sources.download(ctx, config, chain, driver, pkg, version, dir)
+	if err != nil {
+		return err
+	}
+	return uploadAndVerify(ctx, config, chain, queue, driver, pkg, version, files)
+}
+
+// uploadAndVerify uploads downloaded files to the destination of the chain and
+// compares the hashes, a mismatching version is deleted from the destination.
+func uploadAndVerify(ctx context.Context, config *Config, chain SyncChain, queue *deleteQueue, driver FeedDriver, pkg Package, version string, files []transferFile) error {
+	err := driver.Upload(ctx, chain, pkg, version, files, config.Timeout)
+	if err != nil {
+		return err
+	}
+
+	SrcHash, DestHash, err := driver.Hash(ctx, chain, pkg, version, files, config.Timeout)
+	if err != nil {
+		return err
+	}
+	if chain.Mode == "bidirectional" && SrcHash != DestHash {
+		// the version was published on the destination meanwhile, it is not deleted as in one-way sync
+		return fmt.Errorf("conflict: %s/%s:%s has hash %s on %s/%s and %s on %s/%s", pkg.Group, pkg.Name, version, SrcHash, chain.Source.URL, chain.Source.Feed, DestHash, chain.Destination.URL, chain.Destination.Feed)
+	}
+	return verifyPackageHash(ctx, chain, pkg, version, SrcHash, DestHash, func() error {
+		remapped := chain.remap(pkg)
+		return queue.push(ctx, config, chain, deleteKindMismatch, deleteQueueItem{
+			URL:     chain.Destination.URL,
+			Feed:    chain.Destination.Feed,
+			Group:   remapped.Group,
+			Name:    remapped.Name,
+			Version: version,
+			Reason:  "hash mismatch",
+			Queued:  time.Now(),
+		})
+	})
 }
 
-func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, timeoutConfig TimeoutConfig) (error, int) {
+func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, timeoutConfig TimeoutConfig) (fileHash, error, int) {
 	parsedURL, err := url.Parse(URL)
 	if err != nil {
-		return fmt.Errorf("failed to parse url: %s", err), 0
+		return fileHash{}, fmt.Errorf("failed to parse url: %s", err), 0
 	}
 	baseURL := parsedURL.Scheme + "://" + parsedURL.Host
 
 	log.Info().Str("url", baseURL).Str("feed", chain.Feed).Str("Action", "Download").Msgf("Download file %s", filepath.Base(filePath))
 
 	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
-	req.Header.Set("X-ApiKey", chain.APIKey)
 	if err != nil {
-		return err, 0
+		return fileHash{}, err, 0
 	}
+	req.Header.Set("X-ApiKey", chain.APIKey)
 
 	client := &http.Client{
 		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
@@ -283,21 +426,24 @@ func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig,
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
 	} else {
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": "deadline", "method": req.Method}).Inc()
+		return fileHash{}, fmt.Errorf("failed to download %s: %w", filepath.Base(filePath), err), 0
 	}
 	defer resp.Body.Close()
 
 	if err != nil || resp.StatusCode != 200 {
-		return fmt.Errorf("failed to download %s. Status: %d", filepath.Base(filePath), resp.StatusCode), resp.StatusCode
+		return fileHash{}, fmt.Errorf("failed to download %s. Status: %d", filepath.Base(filePath), resp.StatusCode), resp.StatusCode
 	}
 
 	contentType := resp.Header.Get("Content-Type")
 	contentLength := resp.Header.Get("Content-Length")
 
-	if !strings.Contains(contentType, "application") {
-		return fmt.Errorf("invalid content type: %s", contentType), resp.StatusCode
+	// checksum files and poms of maven feeds are served as text, html means an error page
+	if strings.Contains(contentType, "text/html") || !(strings.Contains(contentType, "application") || strings.HasPrefix(contentType, "text/")) {
+		return fileHash{}, fmt.Errorf("invalid content type: %s", contentType), resp.StatusCode
 	}
-	if contentLength == "" || contentLength == "0" {
-		return fmt.Errorf("invalid content length: %s", contentLength), resp.StatusCode
+	// chunked responses of NuGet v3 servers come without Content-Length, empty bodies are caught after copy
+	if contentLength == "0" {
+		return fileHash{}, fmt.Errorf("invalid content length: %s", contentLength), resp.StatusCode
 	}
 
 	if resp.StatusCode == 200 {
@@ -306,7 +452,7 @@ func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig,
 		err := os.MkdirAll(dir, os.ModePerm)
 		if err != nil {
 			fmt.Println("Error creating directories:", err)
-			return err, resp.StatusCode
+			return fileHash{}, err, resp.StatusCode
 		}
 
 		if *debug {
@@ -316,7 +462,7 @@ func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig,
 		out, err := os.Create(filePath)
 		if err != nil {
 			fmt.Println("Error creating file:", err)
-			return err, resp.StatusCode
+			return fileHash{}, err, resp.StatusCode
 		}
 
 		if *debug {
@@ -324,25 +470,39 @@ func downloadFile(ctx context.Context, URL, filePath string, chain ProgetConfig,
 		}
 
 		hasher := sha1.New()
-		multiWriter := io.MultiWriter(out, hasher)
+		sha512Hasher := sha512.New()
+		md5Hasher := md5.New()
+		sha256Hasher := sha256.New()
+		multiWriter := io.MultiWriter(out, hasher, sha512Hasher, md5Hasher, sha256Hasher)
 
 		fileInfo, err := io.Copy(multiWriter, resp.Body)
 		if err != nil {
 			log.Error().Err(err).Str("url", baseURL).Str("Action", "Download").Msgf("Failed to copy response body")
-			return err, resp.StatusCode
+			return fileHash{}, err, resp.StatusCode
 		}
 
 		out.Close()
+		if fileInfo == 0 {
+			return fileHash{}, fmt.Errorf("empty response body for %s", filepath.Base(filePath)), resp.StatusCode
+		}
 		sha1Hash := fmt.Sprintf("%x", hasher.Sum(nil))
 		fileSizeMB := float64(fileInfo) / (1024 * 1024)
 		log.Info().Str("url", baseURL).Str("feed", chain.Feed).Msgf("Success download %s. File Size: %.2f MB. sha1: %s", strings.TrimPrefix(filePath, "packages\\"), fileSizeMB, sha1Hash)
-		return nil, resp.StatusCode
+		return fileHash{
+			MD5:    fmt.Sprintf("%x", md5Hasher.Sum(nil)),
+			SHA1:   sha1Hash,
+			SHA256: fmt.Sprintf("%x", sha256Hasher.Sum(nil)),
+			SHA512: fmt.Sprintf("%x", sha512Hasher.Sum(nil)),
+		}, nil, resp.StatusCode
 	}
-	return err, resp.StatusCode
+	return fileHash{}, err, resp.StatusCode
 
 }
 
-func uploadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, timeoutConfig TimeoutConfig) (error, int) {
+// uploadRequest builds the upload request of an opened file.
+type uploadRequest func(ctx context.Context, URL string, file *os.File, feed ProgetConfig) (*http.Request, error)
+
+func uploadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, timeoutConfig TimeoutConfig, newRequest uploadRequest) (error, int) {
 	parsedURL, err := url.Parse(URL)
 	if err != nil {
 		return fmt.Errorf("failed to parse url: %s", err), 0
@@ -362,42 +522,18 @@ func uploadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, t
 
 	log.Debug().Str("url", baseURL).Str("feed", chain.Feed).Str("Action", "Upload").Msgf("create upload reqeest. File: %s", filepath.Base(filePath))
 
-	var req *http.Request
-	if chain.Type == "nuget" {
-		var requestBody bytes.Buffer
-		writer := multipart.NewWriter(&requestBody)
-
-		part, err := writer.CreateFormFile("package", filepath.Base(filePath))
-		if err != nil {
-			return fmt.Errorf("failed to create form file: %w", err), 0
-		}
-
-		_, err = io.Copy(part, file)
-		if err != nil {
-			return fmt.Errorf("failed to copy file: %w", err), 0
-		}
-
-		err = writer.Close()
-		if err != nil {
-			return fmt.Errorf("failed to close writer: %w", err), 0
-		}
-
-		req, err = http.NewRequestWithContext(ctx, "PUT", URL, &requestBody)
-		req.Header.Set("Content-Type", writer.FormDataContentType())
-	} else {
-		req, err = http.NewRequestWithContext(ctx, "PUT", URL, file)
-	}
-
-	req.Header.Add("X-ApiKey", chain.APIKey)
+	req, err := newRequest(ctx, URL, file, chain)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err), 0
 	}
+	req.Header.Add("X-ApiKey", chain.APIKey)
 
 	resp, err := client.Do(req)
 	if resp != nil {
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
 	} else {
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": "deadline", "method": req.Method}).Inc()
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(filePath), err), 0
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -412,17 +548,14 @@ func uploadFile(ctx context.Context, URL, filePath string, chain ProgetConfig, t
 		}
 	}(resp.Body)
 
-	if err != nil || resp.StatusCode != 201 {
+	// ProGet answers 201, NuGet v3 servers may accept the push with 200 or 202
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return fmt.Errorf("failed to upload %s. Status: %d", filepath.Base(filePath), resp.StatusCode), resp.StatusCode
 	}
 
-	if resp.StatusCode == 201 {
-		log.Info().Str("url", baseURL).Str("feed", chain.Feed).Str("Action", "Upload").Msgf("Success upload: for file %s", strings.TrimSuffix(strings.TrimPrefix(filePath, "packages\\"), ".upack"))
-		err = os.Remove(filePath)
-		return nil, resp.StatusCode
-	}
-
-	return err, resp.StatusCode
+	log.Info().Str("url", baseURL).Str("feed", chain.Feed).Str("Action", "Upload").Msgf("Success upload: for file %s", strings.TrimSuffix(strings.TrimPrefix(filePath, "packages\\"), ".upack"))
+	err = os.Remove(filePath)
+	return nil, resp.StatusCode
 }
 
 func deleteFile(ctx context.Context, URL, apikey, feed, group, name, version string, timeoutConfig TimeoutConfig) (error, int) {
@@ -450,6 +583,7 @@ func deleteFile(ctx context.Context, URL, apikey, feed, group, name, version str
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
 	} else {
 		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": "deadline", "method": req.Method}).Inc()
+		return fmt.Errorf("failed to delete %s/%s:%s: %w", group, name, version, err), 0
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -464,6 +598,9 @@ func deleteFile(ctx context.Context, URL, apikey, feed, group, name, version str
 		}
 	}(resp.Body)
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return newRateLimitError(resp, fmt.Errorf("failed to delete %s/%s:%s, rate limit exceeded", group, name, version)), resp.StatusCode
+	}
 	if err != nil || resp.StatusCode != 200 {
 		log.Debug().Str("Action", "Delete").Msgf("Delete response body: %s", bodyString)
 		return fmt.Errorf("failed to delete %s/%s:%s", group, name, version), resp.StatusCode
@@ -476,59 +613,17 @@ func deleteFile(ctx context.Context, URL, apikey, feed, group, name, version str
 	return err, resp.StatusCode
 }
 
-func checkPackageHash(ctx context.Context, chain SyncChain, pkg Package, version string, timeoutConfig TimeoutConfig) error {
-	var (
-		destHashURL,
-		srcHashURL,
-		deleteURL string
-	)
-
-	switch chain.Type {
-	case "upack":
-		destHashURL = cleanURL(fmt.Sprintf("%s/%s/%s/versions?group=%s&name=%s&version=%s", chain.Destination.URL, chain.Destination.Type, chain.Destination.Feed, pkg.Group, pkg.Name, version))
-		srcHashURL = cleanURL(fmt.Sprintf("%s/%s/%s/versions?group=%s&name=%s&version=%s", chain.Source.URL, chain.Source.Type, chain.Source.Feed, pkg.Group, pkg.Name, version))
-		deleteURL = cleanURL(fmt.Sprintf("%s/api/packages/%s/delete?group=%s&name=%s&version=%s", chain.Destination.URL, chain.Destination.Feed, pkg.Group, pkg.Name, version))
-	case "nuget":
-		// have no api to get hash
-		log.Warn().Msgf("have no api to check nuget hash")
-		//deleteURL = cleanURL(fmt.Sprintf("%s/api/packages/%s/delete?name=%s&version=%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name, version))
-		return nil
-	case "asset":
-		destHashURL = cleanURL(fmt.Sprintf("%s/endpoints/%s/metadata/%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name))
-		srcHashURL = cleanURL(fmt.Sprintf("%s/endpoints/%s/metadata/%s", chain.Source.URL, chain.Source.Feed, pkg.Name))
-		deleteURL = cleanURL(fmt.Sprintf("%s/endpoints/%s/delete/%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name))
-	}
-
-	SrcHash, err := getPackageHash(ctx, srcHashURL, chain.Source.APIKey, chain.Source.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
-	if err != nil {
-		return err
-	}
-
-	DestHash, err := getPackageHash(ctx, destHashURL, chain.Destination.APIKey, chain.Destination.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
-	if err != nil {
-		return err
-	}
+// verifyPackageHash queues the version for deletion from the destination when
+// its hash differs from the source, so it is synced again once deleted. The
+// delete goes through the delete queue with its budget, Retry-After and archive.
+func verifyPackageHash(ctx context.Context, chain SyncChain, pkg Package, version, SrcHash, DestHash string, queueDelete func() error) error {
 	if DestHash != SrcHash {
 		log.Warn().Msgf("File %s/%s:%s hash does not match, delete it", pkg.Group, pkg.Name, version)
-		for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
-			log.Warn().Msgf("Attempt %d to delete %s/%s:%s", attempt, pkg.Group, pkg.Name, version)
-			err, statusCode := deleteFile(ctx, deleteURL, chain.Destination.APIKey, chain.Destination.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
-			switch statusCode {
-			case 429:
-				log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Delete").Msgf("Delete reqest rate limit was exeed. Skip retention")
-				return nil
-			case 403:
-				log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Delete").Msgf("Add \"delete\" permission to apiKey")
-				return nil
-			}
-			if err != nil {
-				log.Error().Err(err).Msgf("Failed to delete %s (attempt: %d)", *savePath, attempt)
-				time.Sleep(5 * time.Duration(attempt) * time.Second)
-			}
-			if attempt == timeoutConfig.MaxRetries {
-				return fmt.Errorf("failed to delete %s", *savePath)
-			}
+		err := queueDelete()
+		if err != nil {
+			return fmt.Errorf("hash mismatch for %s/%s:%s, failed to queue it for deletion: %w", pkg.Group, pkg.Name, version, err)
 		}
+		return fmt.Errorf("hash mismatch for %s/%s:%s, queued for deletion from destination to sync again", pkg.Group, pkg.Name, version)
 	}
 	log.Warn().Msgf("%s/%s:%s hash match", pkg.Group, pkg.Name, version)
 	PackageProceedTotal.With(prometheus.Labels{"feed": chain.Destination.Feed}).Inc()
@@ -588,8 +683,14 @@ func getPackageHash(ctx context.Context, URL, apikey, feed, group, name, version
 }
 
 // gpt-4o
-func decodeXML(bodyStr string) ([]Package, error) {
-	var packages []Package
+// decodeXML appends the entries of one OData page to packages and returns the
+// href of the Atom <link rel="next"> continuation (empty on the last page) and
+// the number of entries found on the page.
+func decodeXML(bodyStr string, packages []Package) ([]Package, string, int, error) {
+	var (
+		next    string
+		entries int
+	)
 	decoder := xml.NewDecoder(strings.NewReader(bodyStr))
 	for {
 		t, err := decoder.Token()
@@ -597,15 +698,32 @@ func decodeXML(bodyStr string) ([]Package, error) {
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return nil, "", 0, err
 		}
 		switch se := t.(type) {
 		case xml.StartElement:
+			if se.Name.Local == "entry" {
+				entries++
+			}
+			if se.Name.Local == "link" {
+				var rel, href string
+				for _, attr := range se.Attr {
+					switch attr.Name.Local {
+					case "rel":
+						rel = attr.Value
+					case "href":
+						href = attr.Value
+					}
+				}
+				if rel == "next" {
+					next = href
+				}
+			}
 			if se.Name.Local == "id" {
 				var id string
 				err := decoder.DecodeElement(&id, &se)
 				if err != nil {
-					return nil, err
+					return nil, "", 0, err
 				}
 				parts := strings.Split(id, "Packages(Id='")
 				if len(parts) > 1 {
@@ -617,7 +735,15 @@ func decodeXML(bodyStr string) ([]Package, error) {
 						found := false
 						for i, pkg := range packages {
 							if pkg.Name == name {
-								packages[i].Versions = append(packages[i].Versions, version)
+								for _, v := range pkg.Versions {
+									if v == version {
+										found = true
+										break
+									}
+								}
+								if !found {
+									packages[i].Versions = append(packages[i].Versions, version)
+								}
 								found = true
 								break
 							}
@@ -635,65 +761,7 @@ func decodeXML(bodyStr string) ([]Package, error) {
 			}
 		}
 	}
-	return packages, nil
-}
-
-func fetchAssets(client *http.Client, url string, parentPath, apiKey string) ([]Asset, error) {
-	var allAssets []Asset
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("X-ApiKey", apiKey)
-	resp, err := client.Do(req)
-	if resp != nil {
-		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": strconv.Itoa(resp.StatusCode), "method": req.Method}).Inc()
-	} else {
-		HttpRequestsTotal.With(prometheus.Labels{"action": "get_packages", "code": "deadline", "method": req.Method}).Inc()
-	}
-
-	if err != nil {
-		return nil, err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-
-		}
-	}(resp.Body)
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error fetching assets: %v", resp.Status)
-	}
-
-	var assets []Asset
-	err = json.Unmarshal(body, &assets)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, asset := range assets {
-		fullName := parentPath + "/" + asset.Name
-		if asset.Type == "dir" {
-			subAssets, err := fetchAssets(client, url+"/"+asset.Name, fullName, apiKey)
-			if err != nil {
-				return nil, err
-			}
-			allAssets = append(allAssets, subAssets...)
-		} else {
-			asset.Name = fullName
-			allAssets = append(allAssets, asset)
-		}
-	}
-
-	return allAssets, nil
+	return packages, next, entries, nil
 }
 
 func apiCall(client *http.Client, req *http.Request) (*http.Response, []byte, error) {
@@ -725,3 +793,47 @@ func apiCall(client *http.Client, req *http.Request) (*http.Response, []byte, er
 
 	return resp, bodyBytes, nil
 }
+
+// getBody performs a GET request with retries and returns the response body.
+// A 404 is returned immediately so callers can fall back to another resource.
+func getBody(ctx context.Context, client *http.Client, URL string, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, accept ...string) ([]byte, error, int) {
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err, 0
+	}
+	req.Header.Set("X-ApiKey", progetConfig.APIKey)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+
+	statusCode := 0
+	for attempt := 1; attempt <= timeoutConfig.MaxRetries; attempt++ {
+		resp, body, err := apiCall(client, req)
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		if statusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s not found", URL), statusCode
+		}
+		if err != nil || statusCode != http.StatusOK {
+			log.Error().Err(err).Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Attempt %d failed to get %s. Status: %d", attempt, URL, statusCode)
+			time.Sleep(5 * time.Duration(attempt) * time.Second)
+			continue
+		}
+		return body, nil, statusCode
+	}
+	return nil, fmt.Errorf("failed to get %s after %d attempts", URL, timeoutConfig.MaxRetries), statusCode
+}
+
+// getJSON performs a GET request with retries and decodes the JSON body into v.
+func getJSON(ctx context.Context, client *http.Client, URL string, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, v interface{}) (error, int) {
+	body, err, statusCode := getBody(ctx, client, URL, progetConfig, timeoutConfig, "application/json")
+	if err != nil {
+		return err, statusCode
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", URL, err), statusCode
+	}
+	return nil, statusCode
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const pypiSimpleJSON = "application/vnd.pypi.simple.v1+json"
+
+var (
+	pypiNormalizeRegexp = regexp.MustCompile(`[-_.]+`)
+	pypiAnchorRegexp    = regexp.MustCompile(`(?s)<a\s[^>]*href="([^"]+)"[^>]*>(.*?)</a>`)
+	pypiSdistSuffixes   = []string{".tar.gz", ".tar.bz2", ".tgz", ".zip"}
+)
+
+type pypiFile struct {
+	Filename string            `json:"filename"`
+	URL      string            `json:"url"`
+	Hashes   map[string]string `json:"hashes"`
+}
+
+type pypiProject struct {
+	Name  string     `json:"name"`
+	Files []pypiFile `json:"files"`
+}
+
+// pypiNormalize returns the PEP 503 normalized project name.
+func pypiNormalize(name string) string {
+	return strings.ToLower(pypiNormalizeRegexp.ReplaceAllString(name, "-"))
+}
+
+func pypiSimpleURL(progetConfig ProgetConfig) string {
+	return cleanURL(fmt.Sprintf("%s/pypi/%s/simple", progetConfig.URL, progetConfig.Feed))
+}
+
+// pypiFileVersion extracts the version from a wheel or sdist file name.
+func pypiFileVersion(project, filename string) (string, bool) {
+	if strings.HasSuffix(filename, ".whl") {
+		parts := strings.Split(strings.TrimSuffix(filename, ".whl"), "-")
+		if len(parts) < 5 {
+			return "", false
+		}
+		return parts[1], true
+	}
+	for _, suffix := range pypiSdistSuffixes {
+		if !strings.HasSuffix(filename, suffix) {
+			continue
+		}
+		base := strings.TrimSuffix(filename, suffix)
+		// sdist project names may contain dashes, find the prefix matching the project
+		for i := 1; i < len(base); i++ {
+			if base[i] == '-' && pypiNormalize(base[:i]) == project {
+				return base[i+1:], true
+			}
+		}
+		if i := strings.LastIndex(base, "-"); i > 0 {
+			return base[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// pypiFileType returns the filetype and pyversion fields of the legacy upload API.
+func pypiFileType(filename string) (string, string) {
+	if strings.HasSuffix(filename, ".whl") {
+		parts := strings.Split(strings.TrimSuffix(filename, ".whl"), "-")
+		return "bdist_wheel", parts[len(parts)-3]
+	}
+	return "sdist", "source"
+}
+
+// getPypiIndex reads a project page of the simple index, JSON (PEP 691) when
+// the server supports it and HTML (PEP 503) otherwise.
+func getPypiIndex(ctx context.Context, client *http.Client, pageURL string, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, project *pypiProject) error {
+	body, err, _ := getBody(ctx, client, pageURL, progetConfig, timeoutConfig, pypiSimpleJSON, "text/html;q=0.1")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, project); err == nil {
+		return nil
+	}
+	project.Files, err = parsePypiAnchors(pageURL, body)
+	return err
+}
+
+// parsePypiAnchors reads the anchors of a PEP 503 page, the hash is taken from the URL fragment.
+func parsePypiAnchors(pageURL string, body []byte) ([]pypiFile, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	var files []pypiFile
+	for _, match := range pypiAnchorRegexp.FindAllStringSubmatch(string(body), -1) {
+		ref, err := url.Parse(html.UnescapeString(match[1]))
+		if err != nil {
+			continue
+		}
+		file := pypiFile{
+			Filename: strings.TrimSpace(html.UnescapeString(match[2])),
+			Hashes:   make(map[string]string),
+		}
+		if algorithm, value, ok := strings.Cut(ref.Fragment, "="); ok {
+			file.Hashes[algorithm] = value
+		}
+		ref.Fragment = ""
+		file.URL = base.ResolveReference(ref).String()
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// getPypiFiles returns the files of a project grouped by version. The versions
+// are listed in reverse index order, the index lists oldest uploads first.
+func getPypiFiles(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, project string) ([]string, map[string][]pypiFile, error) {
+	pageURL := fmt.Sprintf("%s/%s/", pypiSimpleURL(progetConfig), project)
+	var page pypiProject
+	err := getPypiIndex(ctx, client, pageURL, progetConfig, timeoutConfig, &page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var versions []string
+	files := make(map[string][]pypiFile)
+	for _, file := range page.Files {
+		version, ok := pypiFileVersion(project, file.Filename)
+		if !ok {
+			log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Skip file %s, unknown format", file.Filename)
+			continue
+		}
+		if _, exists := files[version]; !exists {
+			versions = append(versions, version)
+		}
+		file.URL, err = resolveURL(pageURL, file.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[version] = append(files[version], file)
+	}
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, files, nil
+}
+
+func getPypiPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting pypi packages")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	indexURL := pypiSimpleURL(progetConfig) + "/"
+	body, err, _ := getBody(ctx, client, indexURL, progetConfig, timeoutConfig, pypiSimpleJSON, "text/html;q=0.1")
+	if err != nil {
+		return nil, err
+	}
+
+	var root struct {
+		Projects []struct {
+			Name string `json:"name"`
+		} `json:"projects"`
+	}
+	var names []string
+	if err := json.Unmarshal(body, &root); err == nil {
+		for _, project := range root.Projects {
+			names = append(names, project.Name)
+		}
+	} else {
+		anchors, err := parsePypiAnchors(indexURL, body)
+		if err != nil {
+			return nil, err
+		}
+		for _, anchor := range anchors {
+			names = append(names, anchor.Filename)
+		}
+	}
+
+	var packages []Package
+	seen := make(map[string]bool)
+	for _, name := range names {
+		project := pypiNormalize(name)
+		if seen[project] {
+			continue
+		}
+		seen[project] = true
+		versions, _, err := getPypiFiles(ctx, client, progetConfig, timeoutConfig, project)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		packages = append(packages, Package{
+			Group:    "",
+			Name:     project,
+			Versions: versions,
+		})
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+type pypiDriver struct{}
+
+func (pypiDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getPypiPackages(ctx, feed, timeoutConfig)
+}
+
+func (pypiDriver) CompareVersions(a, b string) int {
+	return comparePep440(a, b)
+}
+
+func (pypiDriver) IsPrerelease(version string) bool {
+	return isPep440Prerelease(version)
+}
+
+// Download saves every wheel and sdist of one version and checks them against the sha256 of the index.
+func (pypiDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	_, index, err := getPypiFiles(ctx, client, feed, timeoutConfig, pkg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(index[version]) == 0 {
+		return nil, fmt.Errorf("no files found for %s %s", pkg.Name, version)
+	}
+
+	files := make([]transferFile, 0, len(index[version]))
+	for _, file := range index[version] {
+		file := file
+		filePath := filepath.Join(dir, file.Filename)
+		hash, err := downloadWithRetries(ctx, file.URL, filePath, feed, timeoutConfig, func(hash fileHash) error {
+			if file.Hashes["sha256"] != "" && !strings.EqualFold(file.Hashes["sha256"], hash.SHA256) {
+				return fmt.Errorf("sha256 mismatch for %s: index %s, downloaded %s", file.Filename, file.Hashes["sha256"], hash.SHA256)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, transferFile{Path: filePath, Hash: hash})
+	}
+	return files, nil
+}
+
+func (pypiDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	uploadURL := cleanURL(fmt.Sprintf("%s/pypi/%s/legacy", chain.Destination.URL, chain.Destination.Feed))
+	for _, file := range files {
+		err := uploadWithRetries(ctx, uploadURL, file.Path, chain.Destination, timeoutConfig, pypiUploadRequest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash lists "file sha256" of every file as downloaded and as published by the
+// destination index. A file the index lists without a sha256 is downloaded
+// from the destination and hashed, an unknown hash is not a mismatch.
+func (pypiDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	_, destFiles, err := getPypiFiles(ctx, client, chain.Destination, timeoutConfig, pkg.Name)
+	if err != nil {
+		return "", "", err
+	}
+	published := make(map[string]pypiFile)
+	for _, file := range destFiles[version] {
+		published[file.Filename] = file
+	}
+	var SrcHash, DestHash strings.Builder
+	for _, file := range files {
+		name := filepath.Base(file.Path)
+		destHash := strings.ToLower(published[name].Hashes["sha256"])
+		if destFile, ok := published[name]; ok && destHash == "" {
+			verifyPath := filepath.Join(filepath.Dir(file.Path), name+".verify")
+			stored, err := downloadWithRetries(ctx, destFile.URL, verifyPath, chain.Destination, timeoutConfig, nil)
+			os.Remove(verifyPath)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to download %s from destination to check hash: %w", name, err)
+			}
+			destHash = stored.SHA256
+		}
+		SrcHash.WriteString(fmt.Sprintf("%s %s\n", name, file.Hash.SHA256))
+		DestHash.WriteString(fmt.Sprintf("%s %s\n", name, destHash))
+	}
+	return SrcHash.String(), DestHash.String(), nil
+}
+
+// FeedHash reads the sha256 of every file of the version from the simple API.
+func (pypiDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	_, files, err := getPypiFiles(ctx, client, feed, timeoutConfig, pkg.Name)
+	if err != nil {
+		return "", "", err
+	}
+	var lines []string
+	for _, file := range files[version] {
+		hash := strings.ToLower(file.Hashes["sha256"])
+		if hash == "" {
+			return "sha256", "", nil
+		}
+		lines = append(lines, fmt.Sprintf("%s %s\n", file.Filename, hash))
+	}
+	sort.Strings(lines)
+	return "sha256", strings.Join(lines, ""), nil
+}
+
+func (pypiDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	return deletePackage(ctx, feed, pkg, version, url.Values{"name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// pypiUploadRequest sends the file with the fields of the legacy "file_upload" action.
+func pypiUploadRequest(ctx context.Context, URL string, file *os.File, feed ProgetConfig) (*http.Request, error) {
+	sha256Hasher := sha256.New()
+	_, err := io.Copy(sha256Hasher, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+	err = writePypiUploadForm(writer, file.Name(), fmt.Sprintf("%x", sha256Hasher.Sum(nil)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form: %w", err)
+	}
+	part, err := writer.CreateFormFile("content", filepath.Base(file.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", URL, &requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth("api", feed.APIKey)
+	return req, nil
+}
+
+// writePypiUploadForm writes the fields of the legacy "file_upload" action.
+func writePypiUploadForm(writer *multipart.Writer, filePath string, sha256Digest string) error {
+	filename := filepath.Base(filePath)
+	filetype, pyversion := pypiFileType(filename)
+	base := strings.TrimSuffix(filename, ".whl")
+	for _, suffix := range pypiSdistSuffixes {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	name := strings.SplitN(base, "-", 2)[0]
+	if filetype == "sdist" {
+		if i := strings.LastIndex(base, "-"); i > 0 {
+			name = base[:i]
+		}
+	}
+	version, ok := pypiFileVersion(pypiNormalize(name), filename)
+	if !ok {
+		return fmt.Errorf("unknown file format %s", filename)
+	}
+
+	fields := [][2]string{
+		{":action", "file_upload"},
+		{"protocol_version", "1"},
+		{"metadata_version", "1.0"},
+		{"name", name},
+		{"version", version},
+		{"filetype", filetype},
+		{"pyversion", pyversion},
+		{"sha256_digest", sha256Digest},
+	}
+	for _, field := range fields {
+		err := writer.WriteField(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
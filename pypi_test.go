@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPypiHashWithoutPublishedHash(t *testing.T) {
+	stored := "package"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pypi/dest/simple/app/":
+			// the index lists the file without a #sha256= fragment
+			fmt.Fprint(w, `<html><body><a href="../../files/app-1.0.tar.gz">app-1.0.tar.gz</a></body></html>`)
+		case "/pypi/dest/files/app-1.0.tar.gz":
+			w.Write([]byte(stored))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "app-1.0.tar.gz")
+	if err := os.WriteFile(filePath, []byte("package"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := SyncChain{Type: "pypi", Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "pypi"}}
+	files := []transferFile{{Path: filePath, Hash: hash}}
+	timeout := TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1}
+
+	// the stored file is downloaded and hashed instead of reporting a mismatch
+	SrcHash, DestHash, err := pypiDriver{}.Hash(context.Background(), chain, Package{Name: "app"}, "1.0", files, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SrcHash != DestHash {
+		t.Errorf("hashes differ for the same file:\n%s\n%s", SrcHash, DestHash)
+	}
+	if matches, _ := filepath.Glob(filePath + ".verify"); len(matches) != 0 {
+		t.Errorf("verify file left: %v", matches)
+	}
+
+	stored = "corrupt"
+	SrcHash, DestHash, err = pypiDriver{}.Hash(context.Background(), chain, Package{Name: "app"}, "1.0", files, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SrcHash == DestHash {
+		t.Error("hashes match for a different stored file")
+	}
+}
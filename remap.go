@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// compileRemap compiles the expressions of the rules once, remap uses them for every package.
+func compileRemap(rules []RemapRule) {
+	for i := range rules {
+		// the expressions are checked by validateConfig
+		if rules[i].Group != "" {
+			rules[i].groupRegexp = regexp.MustCompile(rules[i].Group)
+		}
+		if rules[i].Name != "" {
+			rules[i].nameRegexp = regexp.MustCompile(rules[i].Name)
+		}
+	}
+}
+
+// remap returns the group and name the package has on the destination. The
+// first rule whose expressions match the package rewrites it, a rule without
+// toGroup or toName keeps that part.
+func (chain SyncChain) remap(pkg Package) Package {
+	for _, rule := range chain.Remap {
+		if rule.groupRegexp != nil && !rule.groupRegexp.MatchString(pkg.Group) {
+			continue
+		}
+		if rule.nameRegexp != nil && !rule.nameRegexp.MatchString(pkg.Name) {
+			continue
+		}
+
+		remapped := Package{Group: pkg.Group, Name: pkg.Name, Versions: pkg.Versions}
+		if rule.ToGroup != "" {
+			remapped.Group = rewrite(rule.groupRegexp, pkg.Group, rule.ToGroup)
+		}
+		if rule.ToName != "" {
+			remapped.Name = rewrite(rule.nameRegexp, pkg.Name, rule.ToName)
+		}
+		return remapped
+	}
+	return pkg
+}
+
+// rewrite expands $1 style references of the match, without an expression the template is the value.
+func rewrite(expression *regexp.Regexp, value, template string) string {
+	if expression == nil {
+		return template
+	}
+	return expression.ReplaceAllString(value, template)
+}
+
+func isRemapped(pkg, remapped Package) bool {
+	return pkg.Group != remapped.Group || pkg.Name != remapped.Name
+}
+
+// rewriteUpackManifest sets the group and name in upack.json of the package
+// file and returns the hashes of the rewritten file. Other entries and
+// manifest fields are kept as they are.
+func rewriteUpackManifest(filePath string, pkg Package) (fileHash, error) {
+	reader, err := zip.OpenReader(filePath)
+	if err != nil {
+		return fileHash{}, fmt.Errorf("failed to open %s: %w", filepath.Base(filePath), err)
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*")
+	if err != nil {
+		return fileHash{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := zip.NewWriter(tmp)
+	found := false
+	for _, file := range reader.File {
+		if file.Name != "upack.json" {
+			err = writer.Copy(file)
+			if err != nil {
+				tmp.Close()
+				return fileHash{}, err
+			}
+			continue
+		}
+		found = true
+		manifest, err := readUpackManifest(file)
+		if err != nil {
+			tmp.Close()
+			return fileHash{}, err
+		}
+		manifest["name"] = pkg.Name
+		if pkg.Group != "" {
+			manifest["group"] = pkg.Group
+		} else {
+			delete(manifest, "group")
+		}
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			tmp.Close()
+			return fileHash{}, err
+		}
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: file.Modified})
+		if err != nil {
+			tmp.Close()
+			return fileHash{}, err
+		}
+		_, err = entry.Write(data)
+		if err != nil {
+			tmp.Close()
+			return fileHash{}, err
+		}
+	}
+	err = writer.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileHash{}, err
+	}
+	if !found {
+		return fileHash{}, fmt.Errorf("upack.json not found in %s", filepath.Base(filePath))
+	}
+
+	reader.Close()
+	err = os.Rename(tmp.Name(), filePath)
+	if err != nil {
+		return fileHash{}, err
+	}
+	return hashFile(filePath)
+}
+
+func readUpackManifest(file *zip.File) (map[string]interface{}, error) {
+	in, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var manifest map[string]interface{}
+	err = json.NewDecoder(in).Decode(&manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode upack.json: %w", err)
+	}
+	return manifest, nil
+}
+
+func hashFile(filePath string) (fileHash, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fileHash{}, err
+	}
+	defer file.Close()
+	md5Hasher, sha1Hasher, sha256Hasher, sha512Hasher := md5.New(), sha1.New(), sha256.New(), sha512.New()
+	_, err = io.Copy(io.MultiWriter(md5Hasher, sha1Hasher, sha256Hasher, sha512Hasher), file)
+	if err != nil {
+		return fileHash{}, err
+	}
+	return fileHash{
+		MD5:    fmt.Sprintf("%x", md5Hasher.Sum(nil)),
+		SHA1:   fmt.Sprintf("%x", sha1Hasher.Sum(nil)),
+		SHA256: fmt.Sprintf("%x", sha256Hasher.Sum(nil)),
+		SHA512: fmt.Sprintf("%x", sha512Hasher.Sum(nil)),
+	}, nil
+}
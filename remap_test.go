@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRemap(t *testing.T) {
+	chain := SyncChain{Remap: []RemapRule{
+		{Group: "^vendor/(.+)$", ToGroup: "mirror/$1"},
+		{Group: "^tools$", Name: "^(.+)-cli$", ToName: "$1"},
+		{Name: "^legacy$", ToGroup: "archive", ToName: "legacy-app"},
+	}}
+	compileRemap(chain.Remap)
+	tests := []struct {
+		pkg  Package
+		want Package
+	}{
+		{Package{Group: "vendor/acme", Name: "lib"}, Package{Group: "mirror/acme", Name: "lib"}},
+		{Package{Group: "tools", Name: "build-cli"}, Package{Group: "tools", Name: "build"}},
+		// the name does not match, the group alone does not apply the rule
+		{Package{Group: "tools", Name: "build"}, Package{Group: "tools", Name: "build"}},
+		// without an expression for the group the template is the value
+		{Package{Group: "any", Name: "legacy"}, Package{Group: "archive", Name: "legacy-app"}},
+		// the first matching rule wins
+		{Package{Group: "vendor/x", Name: "legacy"}, Package{Group: "mirror/x", Name: "legacy"}},
+		{Package{Group: "other", Name: "lib"}, Package{Group: "other", Name: "lib"}},
+	}
+	for _, tt := range tests {
+		got := chain.remap(tt.pkg)
+		if got.Group != tt.want.Group || got.Name != tt.want.Name {
+			t.Errorf("remap(%s/%s) = %s/%s, want %s/%s", tt.pkg.Group, tt.pkg.Name, got.Group, got.Name, tt.want.Group, tt.want.Name)
+		}
+		if isRemapped(tt.pkg, got) != (tt.pkg.Group != tt.want.Group || tt.pkg.Name != tt.want.Name) {
+			t.Errorf("isRemapped(%s/%s) = %v", tt.pkg.Group, tt.pkg.Name, isRemapped(tt.pkg, got))
+		}
+	}
+}
+
+func TestGetPackagesToSyncRemapped(t *testing.T) {
+	chain := SyncChain{Type: "upack", Remap: []RemapRule{{Group: "^vendor$", ToGroup: "mirror"}}}
+	compileRemap(chain.Remap)
+	config := &Config{ProceedPackageLimit: 10, ProceedPackageVersion: 10}
+	source := []Package{{Group: "vendor", Name: "lib", Versions: []string{"2.0.0", "1.0.0"}}}
+	// the destination has the version under the remapped group
+	dest := []Package{{Group: "mirror", Name: "lib", Versions: []string{"1.0.0"}}, {Group: "vendor", Name: "lib", Versions: []string{"2.0.0"}}}
+
+	packages, err := getPackagesToSync(context.Background(), config, chain, source, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{{Group: "vendor", Name: "lib", Versions: []string{"2.0.0"}}}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("packages to sync %v, want %v", packages, want)
+	}
+}
+
+// writeUpackFile writes a package with the manifest and a payload entry.
+func writeUpackFile(t *testing.T, manifest string) string {
+	filePath := filepath.Join(t.TempDir(), "lib.1.0.0.upack")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	for name, content := range map[string]string{"upack.json": manifest, "package/bin/tool": "payload"} {
+		if name == "upack.json" && manifest == "" {
+			continue
+		}
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = entry.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func readUpackEntries(t *testing.T, filePath string) map[string]string {
+	reader, err := zip.OpenReader(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entries := make(map[string]string)
+	for _, file := range reader.File {
+		in, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[file.Name] = string(data)
+	}
+	return entries
+}
+
+func TestRewriteUpackManifest(t *testing.T) {
+	filePath := writeUpackFile(t, `{"group":"vendor","name":"lib","version":"1.0.0","title":"Lib","dependencies":["vendor/core:1.0.0"]}`)
+
+	hash, err := rewriteUpackManifest(filePath, Package{Group: "mirror/vendor", Name: "vendor-lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readUpackEntries(t, filePath)
+	if entries["package/bin/tool"] != "payload" {
+		t.Errorf("payload %q, want it copied", entries["package/bin/tool"])
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal([]byte(entries["upack.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"group":        "mirror/vendor",
+		"name":         "vendor-lib",
+		"version":      "1.0.0",
+		"title":        "Lib",
+		"dependencies": []interface{}{"vendor/core:1.0.0"},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("upack.json %v, want %v", manifest, want)
+	}
+
+	// the returned hashes are the ones of the rewritten file
+	fileHash, err := hashFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != fileHash || hash.SHA1 == "" {
+		t.Errorf("hash %+v, want %+v", hash, fileHash)
+	}
+	if matches, _ := filepath.Glob(filePath + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
+
+func TestRewriteUpackManifestWithoutGroup(t *testing.T) {
+	filePath := writeUpackFile(t, `{"group":"vendor","name":"lib","version":"1.0.0"}`)
+
+	_, err := rewriteUpackManifest(filePath, Package{Name: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal([]byte(readUpackEntries(t, filePath)["upack.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := manifest["group"]; exists {
+		t.Errorf("upack.json %v, want the group removed", manifest)
+	}
+}
+
+func TestRewriteUpackManifestMissing(t *testing.T) {
+	filePath := writeUpackFile(t, "")
+	before, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rewriteUpackManifest(filePath, Package{Group: "g", Name: "a"}); err == nil {
+		t.Fatal("rewriteUpackManifest succeeded without upack.json")
+	}
+	after, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("the package file was changed on error")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// retentionReport collects the decisions retention makes in dry-run during one
+// iteration. It is written as <path>.json and <path>.csv at the end of the iteration.
+type retentionReport struct {
+	Generated time.Time              `json:"generated"`
+	Entries   []retentionReportEntry `json:"entries"`
+}
+
+type retentionReportEntry struct {
+	URL     string `json:"url"`
+	Feed    string `json:"feed"`
+	Type    string `json:"type"`
+	Group   string `json:"group"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+}
+
+func (r *retentionReport) add(chain SyncChain, group, name string, decision retentionDecision) {
+	action := "delete"
+	if decision.Keep {
+		action = "keep"
+	}
+	r.Entries = append(r.Entries, retentionReportEntry{
+		URL:     chain.Destination.URL,
+		Feed:    chain.Destination.Feed,
+		Type:    chain.Type,
+		Group:   group,
+		Name:    name,
+		Version: decision.Version,
+		Action:  action,
+		Reason:  decision.Reason,
+	})
+}
+
+func (r *retentionReport) write(path string) error {
+	r.Generated = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".json", data, 0666)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path + ".csv")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"url", "feed", "type", "group", "name", "version", "action", "reason"})
+	for _, entry := range r.Entries {
+		writer.Write([]string{entry.URL, entry.Feed, entry.Type, entry.Group, entry.Name, entry.Version, entry.Action, entry.Reason})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
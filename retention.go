@@ -4,53 +4,176 @@ import (
 	"context"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"path"
+	"sort"
 	"time"
 )
 
-func retention(ctx context.Context, config *Config, chain SyncChain, packages []Package) error {
+// retention queues the versions the policy does not keep and sends the
+// deletions the delete budget of the destination allows. In dry-run nothing is
+// queued or deleted, the decisions are added to report.
+func retention(ctx context.Context, config *Config, chain SyncChain, packages []Package, queue *deleteQueue, report *retentionReport) error {
+	driver, err := getFeedDriver(chain.Type)
+	if err != nil {
+		return err
+	}
 
+	now := time.Now()
+	var items []deleteQueueItem
 	for _, pkg := range packages {
-		if len(pkg.Versions) <= config.Retention.VersionLimit {
+		policy := config.Retention.policyFor(pkg)
+		if policy.VersionLimit > 0 && len(pkg.Versions) <= policy.VersionLimit {
 			log.Debug().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("package %s have %d version, skip retention", pkg.Name, len(pkg.Versions))
+			if config.Retention.DryRun {
+				for _, version := range pkg.Versions {
+					report.add(chain, pkg.Group, pkg.Name, retentionDecision{Version: version, Keep: true, Reason: fmt.Sprintf("within versionLimit %d", policy.VersionLimit)})
+				}
+			}
 			continue
 		}
 
+		var published map[string]time.Time
+		if dater, ok := driver.(publishDater); ok && policy.KeepDays > 0 {
+			published, err = dater.PublishDates(ctx, chain.Destination, pkg, config.Timeout)
+			if err != nil {
+				log.Error().Err(err).Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Failed to get publish dates of %s/%s, skip retention", pkg.Group, pkg.Name)
+				continue
+			}
+		}
+
 		log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("package %s have %d version, retention", pkg.Name, len(pkg.Versions))
-		for i, version := range pkg.Versions {
-			if i > config.Retention.VersionLimit-1 {
-				for attempt := 1; attempt <= config.Timeout.MaxRetries; attempt++ {
-
-					var deleteURL string
-					switch chain.Type {
-					case "upack":
-						deleteURL = cleanURL(fmt.Sprintf("%s/api/packages/%s/delete?group=%s&name=%s&version=%s", chain.Destination.URL, chain.Destination.Feed, pkg.Group, pkg.Name, version))
-					case "nuget":
-						deleteURL = cleanURL(fmt.Sprintf("%s/api/packages/%s/delete?name=%s&version=%s", chain.Destination.URL, chain.Destination.Feed, pkg.Name, version))
-					}
-
-					log.Warn().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Attempt %d to delete %s/%s:%s", attempt, pkg.Group, pkg.Name, version)
-					err, statusCode := deleteFile(ctx, deleteURL, chain.Destination.APIKey, chain.Destination.Feed, pkg.Group, pkg.Name, version, config.Timeout)
-
-					switch statusCode {
-					case 429:
-						log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Delete").Msgf("Delete reqest rate limit was exeed. Skip retention")
-						return nil
-					case 403:
-						log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Delete").Msgf("Add \"delete\" permission to apiKey")
-						return nil
-					}
-					if err != nil {
-						log.Error().Err(err).Msgf("Failed to delete %s (attempt: %d)", *savePath, attempt)
-						time.Sleep(5 * time.Duration(attempt) * time.Second)
-					} else {
-						break
-					}
-					if attempt == config.Timeout.MaxRetries {
-						return fmt.Errorf("failed to delete %s", *savePath)
-					}
-				}
+		for _, decision := range evaluateRetention(policy, driver, pkg.Versions, published, now) {
+			if config.Retention.DryRun {
+				report.add(chain, pkg.Group, pkg.Name, decision)
+			}
+			if decision.Keep {
+				log.Debug().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Keep %s/%s:%s: %s", pkg.Group, pkg.Name, decision.Version, decision.Reason)
+				continue
+			}
+			log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("%s %s/%s:%s: %s", deleteAction(config), pkg.Group, pkg.Name, decision.Version, decision.Reason)
+			items = append(items, deleteQueueItem{
+				URL:     chain.Destination.URL,
+				Feed:    chain.Destination.Feed,
+				Group:   pkg.Group,
+				Name:    pkg.Name,
+				Version: decision.Version,
+				Reason:  decision.Reason,
+				Queued:  now,
+			})
+		}
+	}
+
+	if config.Retention.DryRun {
+		log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Dry-run: %d versions would be deleted", len(items))
+		return nil
+	}
+	return queue.commit(ctx, config, chain, deleteKindRetention, items)
+}
+
+// assetRetention applies the policy to the files of every asset directory,
+// see evaluateAssetRetention.
+func assetRetention(ctx context.Context, config *Config, chain SyncChain, queue *deleteQueue, report *retentionReport) error {
+	assets, err := getAssets(ctx, chain.Destination, config.Timeout)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var items []deleteQueueItem
+	for _, decision := range evaluateAssetRetention(config.Retention, assets, now) {
+		if config.Retention.DryRun {
+			report.add(chain, "", decision.Name, decision.retentionDecision)
+		}
+		if decision.Keep {
+			log.Debug().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Keep %s: %s", decision.Name, decision.Reason)
+			continue
+		}
+		log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("%s %s: %s", deleteAction(config), decision.Name, decision.Reason)
+		items = append(items, deleteQueueItem{
+			URL:     chain.Destination.URL,
+			Feed:    chain.Destination.Feed,
+			Name:    decision.Name,
+			Version: "0",
+			Reason:  decision.Reason,
+			Queued:  now,
+		})
+	}
+
+	if config.Retention.DryRun {
+		log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Retention").Msgf("Dry-run: %d versions would be deleted", len(items))
+		return nil
+	}
+	return queue.commit(ctx, config, chain, deleteKindRetention, items)
+}
+
+// assetDecision is the verdict of the retention policy for one asset file.
+type assetDecision struct {
+	Name string
+	retentionDecision
+}
+
+// evaluateAssetRetention applies the policy to the files of every asset
+// directory. A directory is a package, its files are versions ordered by
+// modification date, newest first, and the modification date is the publish
+// date of the age rule. Only files matching retention.paths are decided.
+func evaluateAssetRetention(retention RetentionConfig, assets []Asset, now time.Time) []assetDecision {
+	var dirs []string
+	files := make(map[string][]Asset)
+	for _, asset := range assets {
+		if !matchAssetPaths(retention.Paths, asset.Name) {
+			continue
+		}
+		dir := path.Dir(asset.Name)
+		if dir == "." {
+			dir = ""
+		}
+		if _, exists := files[dir]; !exists {
+			dirs = append(dirs, dir)
+		}
+		files[dir] = append(files[dir], asset)
+	}
+
+	var decisions []assetDecision
+	for _, dir := range dirs {
+		dirFiles := files[dir]
+		published := make(map[string]time.Time)
+		for _, file := range dirFiles {
+			if modified, ok := parsePublishTime(file.Modified); ok {
+				published[path.Base(file.Name)] = modified
 			}
 		}
+		sort.SliceStable(dirFiles, func(i, j int) bool {
+			return published[path.Base(dirFiles[i].Name)].After(published[path.Base(dirFiles[j].Name)])
+		})
+		names := make([]string, len(dirFiles))
+		for i, file := range dirFiles {
+			names[i] = path.Base(file.Name)
+		}
+
+		policy := retention.policyFor(Package{Group: dir})
+		for i, decision := range evaluateRetention(policy, assetDriver{}, names, published, now) {
+			decisions = append(decisions, assetDecision{Name: dirFiles[i].Name, retentionDecision: decision})
+		}
+	}
+	return decisions
+}
+
+func deleteAction(config *Config) string {
+	if config.Retention.DryRun {
+		return "Dry-run, would delete"
+	}
+	return "Queue delete"
+}
+
+// matchAssetPaths matches a file against path.Match globs, no globs match every file.
+func matchAssetPaths(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
 	}
-	return nil
+	return false
 }
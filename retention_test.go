@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvaluateRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+	versions := []string{"5.0.0", "5.0.0-rc.1", "4.0.0", "4.0.0-rc.1", "3.0.0", "2.0.0", "1.0.0-lts"}
+	published := map[string]time.Time{
+		"5.0.0":      days(1),
+		"5.0.0-rc.1": days(2),
+		"4.0.0":      days(20),
+		"4.0.0-rc.1": days(25),
+		"3.0.0":      days(40),
+		"1.0.0-lts":  days(400),
+	}
+	tests := []struct {
+		name      string
+		policy    RetentionPolicy
+		published map[string]time.Time
+		keep      []string
+	}{
+		{"versionLimit", RetentionPolicy{VersionLimit: 3}, published, []string{"5.0.0", "5.0.0-rc.1", "4.0.0"}},
+		{"keepStable", RetentionPolicy{KeepStable: 2}, published, []string{"5.0.0", "4.0.0"}},
+		{"keepPrerelease", RetentionPolicy{KeepPrerelease: 1}, published, []string{"5.0.0-rc.1"}},
+		// prereleases counted by the limit still count for keepStable and keepPrerelease
+		{"versionLimit and keepStable", RetentionPolicy{VersionLimit: 2, KeepStable: 2}, published, []string{"5.0.0", "5.0.0-rc.1", "4.0.0"}},
+		{"keepRegex", RetentionPolicy{VersionLimit: 1, KeepRegex: "-lts$"}, published, []string{"5.0.0", "1.0.0-lts"}},
+		// 2.0.0 has no publish date and is kept
+		{"keepDays", RetentionPolicy{KeepDays: 30}, published, []string{"5.0.0", "5.0.0-rc.1", "4.0.0", "4.0.0-rc.1", "2.0.0"}},
+		{"keepDays without dates", RetentionPolicy{KeepDays: 30}, nil, versions},
+		{"all rules", RetentionPolicy{VersionLimit: 1, KeepStable: 2, KeepPrerelease: 1, KeepDays: 30, KeepRegex: "-lts$"}, published, []string{"5.0.0", "5.0.0-rc.1", "4.0.0", "4.0.0-rc.1", "2.0.0", "1.0.0-lts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := evaluateRetention(tt.policy, npmDriver{}, versions, tt.published, now)
+			if len(decisions) != len(versions) {
+				t.Fatalf("%d decisions for %d versions", len(decisions), len(versions))
+			}
+			var keep []string
+			for i, decision := range decisions {
+				if decision.Version != versions[i] {
+					t.Errorf("decision %d is for %s, want %s", i, decision.Version, versions[i])
+				}
+				if decision.Reason == "" {
+					t.Errorf("%s has no reason", decision.Version)
+				}
+				if decision.Keep {
+					keep = append(keep, decision.Version)
+				}
+			}
+			if !reflect.DeepEqual(keep, tt.keep) {
+				t.Errorf("kept %v, want %v", keep, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyFor(t *testing.T) {
+	retention := RetentionConfig{
+		RetentionPolicy: RetentionPolicy{VersionLimit: 5},
+		Overrides: []RetentionOverride{
+			{Group: "tools", Name: "cli-*", RetentionPolicy: RetentionPolicy{VersionLimit: 1}},
+			{Group: "tools", RetentionPolicy: RetentionPolicy{VersionLimit: 2}},
+			{Name: "core", RetentionPolicy: RetentionPolicy{KeepDays: 7}},
+		},
+	}
+	tests := []struct {
+		pkg  Package
+		want RetentionPolicy
+	}{
+		{Package{Group: "tools", Name: "cli-build"}, RetentionPolicy{VersionLimit: 1}},
+		{Package{Group: "tools", Name: "core"}, RetentionPolicy{VersionLimit: 2}},
+		{Package{Group: "libs", Name: "core"}, RetentionPolicy{KeepDays: 7}},
+		{Package{Group: "libs", Name: "app"}, RetentionPolicy{VersionLimit: 5}},
+	}
+	for _, tt := range tests {
+		if got := retention.policyFor(tt.pkg); got != tt.want {
+			t.Errorf("policyFor(%s/%s) = %+v, want %+v", tt.pkg.Group, tt.pkg.Name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		policy RetentionPolicy
+		errors int
+	}{
+		{RetentionPolicy{VersionLimit: 3}, 0},
+		{RetentionPolicy{KeepDays: 30, KeepRegex: "^1\\."}, 0},
+		{RetentionPolicy{}, 1},
+		{RetentionPolicy{KeepRegex: "^1"}, 1},
+		{RetentionPolicy{VersionLimit: -1, KeepDays: 1}, 1},
+		{RetentionPolicy{KeepStable: 1, KeepRegex: "("}, 1},
+	}
+	for _, tt := range tests {
+		if got := validateRetentionPolicy(tt.policy, "retention"); len(got) != tt.errors {
+			t.Errorf("validateRetentionPolicy(%+v) = %v, want %d errors", tt.policy, got, tt.errors)
+		}
+	}
+}
+
+func TestEvaluateAssetRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	assets := []Asset{
+		{Name: "builds/app-1.zip", Modified: "2024-05-01T00:00:00Z"},
+		{Name: "builds/app-3.zip", Modified: "2024-05-30T00:00:00Z"},
+		{Name: "builds/app-2.zip", Modified: "2024-05-20T00:00:00Z"},
+		{Name: "docs/readme.txt", Modified: "2023-01-01T00:00:00Z"},
+		{Name: "release.zip", Modified: "2024-01-01T00:00:00Z"},
+		{Name: "old.zip", Modified: "2023-01-01T00:00:00Z"},
+	}
+	retention := RetentionConfig{
+		Paths:           []string{"builds/*", "*.zip"},
+		RetentionPolicy: RetentionPolicy{VersionLimit: 1},
+		Overrides:       []RetentionOverride{{Group: "builds", RetentionPolicy: RetentionPolicy{KeepDays: 5}}},
+	}
+
+	decisions := make(map[string]bool)
+	for _, decision := range evaluateAssetRetention(retention, assets, now) {
+		decisions[decision.Name] = decision.Keep
+	}
+	// a directory is a package, its files are ordered by the modification date
+	want := map[string]bool{
+		"builds/app-3.zip": true,
+		"builds/app-2.zip": false,
+		"builds/app-1.zip": false,
+		"release.zip":      true,
+		"old.zip":          false,
+	}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("decisions %v, want %v", decisions, want)
+	}
+}
+
+// retentionServer serves upack versions with publish dates per feed and records deletions.
+type retentionServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	published map[string]map[string]time.Time
+	requests  []string
+	deleted   []string
+}
+
+func newRetentionServer(t *testing.T, published map[string]map[string]time.Time) *retentionServer {
+	server := &retentionServer{published: published}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.requests = append(server.requests, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/api/packages/") {
+			server.deleted = append(server.deleted, r.URL.Query().Get("version"))
+			return
+		}
+		feed := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upack/"), "/versions")
+		dates, ok := server.published[feed]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var versions []map[string]string
+		for version, date := range dates {
+			versions = append(versions, map[string]string{"version": version, "published": date.Format(time.RFC3339)})
+		}
+		json.NewEncoder(w).Encode(versions)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *retentionServer) calls() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...), append([]string(nil), s.deleted...)
+}
+
+func retentionTestChain(server *retentionServer) (*Config, SyncChain) {
+	config := &Config{
+		Timeout:               TimeoutConfig{WebRequestTimeout: 5, MaxRetries: 1},
+		ProceedPackageLimit:   10,
+		ProceedPackageVersion: 10,
+	}
+	chain := SyncChain{
+		Type:        "upack",
+		Source:      ProgetConfig{URL: server.URL, Feed: "source", Type: "upack"},
+		Destination: ProgetConfig{URL: server.URL, Feed: "dest", Type: "upack"},
+	}
+	return config, chain
+}
+
+func TestRetentionDeletes(t *testing.T) {
+	now := time.Now()
+	server := newRetentionServer(t, map[string]map[string]time.Time{"dest": {
+		"4.0.0": now.Add(-time.Hour),
+		"3.0.0": now.Add(-10 * 24 * time.Hour),
+		"2.0.0": now.Add(-100 * 24 * time.Hour),
+		"1.0.0": now.Add(-400 * 24 * time.Hour),
+	}})
+	config, chain := retentionTestChain(server)
+	config.Retention = RetentionConfig{Enabled: true, RetentionPolicy: RetentionPolicy{VersionLimit: 1, KeepDays: 30, KeepRegex: "^1\\."}}
+	config.DeleteQueue = DeleteQueueConfig{File: filepath.Join(t.TempDir(), "delete-queue.json")}
+	queue, err := loadDeleteQueue(config.DeleteQueue.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages := []Package{
+		{Group: "g", Name: "app", Versions: []string{"4.0.0", "3.0.0", "2.0.0", "1.0.0"}},
+		// within versionLimit, its publish dates are not read
+		{Group: "g", Name: "single", Versions: []string{"1.0.0"}},
+	}
+
+	// dry-run reports every decision and deletes nothing
+	config.Retention.DryRun = true
+	report := &retentionReport{}
+	err = retention(context.Background(), config, chain, packages, queue, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, deleted := server.calls(); len(deleted) != 0 || len(queue.Items) != 0 {
+		t.Fatalf("dry-run deleted %v and queued %d", deleted, len(queue.Items))
+	}
+	if len(report.Entries) != 5 {
+		t.Errorf("dry-run reported %d decisions, want 5", len(report.Entries))
+	}
+
+	config.Retention.DryRun = false
+	err = retention(context.Background(), config, chain, packages, queue, &retentionReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, deleted := server.calls()
+	if !reflect.DeepEqual(deleted, []string{"2.0.0"}) {
+		t.Errorf("deleted %v, want [2.0.0]", deleted)
+	}
+	for _, request := range requests {
+		if strings.Contains(request, "single") {
+			t.Errorf("requested %s for a package within versionLimit", request)
+		}
+	}
+}
+
+func TestGetPackagesToSyncRetention(t *testing.T) {
+	now := time.Now()
+	// a version synced now is published now on the destination, the age comes from the source
+	server := newRetentionServer(t, map[string]map[string]time.Time{"source": {
+		"3.0.0": now.Add(-10 * 24 * time.Hour),
+		"2.0.0": now.Add(-100 * 24 * time.Hour),
+		"1.0.0": now.Add(-200 * 24 * time.Hour),
+	}})
+	config, chain := retentionTestChain(server)
+	config.Retention = RetentionConfig{Enabled: true, RetentionPolicy: RetentionPolicy{KeepDays: 30, KeepRegex: "^1\\."}}
+	source := []Package{{Group: "g", Name: "app", Versions: []string{"3.0.0", "2.0.0", "1.0.0"}}}
+
+	packages, err := getPackagesToSync(context.Background(), config, chain, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{{Group: "g", Name: "app", Versions: []string{"3.0.0", "1.0.0"}}}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("packages to sync %v, want %v", packages, want)
+	}
+	requests, _ := server.calls()
+	if len(requests) != 1 || requests[0] != "/upack/source/versions" {
+		t.Errorf("requests %v, want the source versions", requests)
+	}
+
+	// dry-run syncs the versions retention would delete
+	config.Retention.DryRun = true
+	packages, err = getPackagesToSync(context.Background(), config, chain, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || len(packages[0].Versions) != 3 {
+		t.Errorf("dry-run packages to sync %v, want all versions", packages)
+	}
+
+	// nothing is missing, the publish dates are not read
+	config.Retention.DryRun = false
+	packages, err = getPackagesToSync(context.Background(), config, chain, source, []Package{{Group: "g", Name: "app", Versions: []string{"3.0.0", "2.0.0", "1.0.0"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := server.calls(); len(packages) != 0 || len(requests) != 2 {
+		t.Errorf("packages to sync %v with %d requests, want none and no new request", packages, len(requests))
+	}
+}
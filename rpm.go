@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type rpmRepomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+type rpmPrimary struct {
+	Packages []rpmPackage `xml:"package"`
+}
+
+type rpmPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Time struct {
+		File int64 `xml:"file,attr"`
+	} `xml:"time"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+}
+
+func rpmRepoURL(progetConfig ProgetConfig) string {
+	return cleanURL(fmt.Sprintf("%s/rpm/%s", progetConfig.URL, progetConfig.Feed))
+}
+
+// rpmVersion returns [epoch:]version-release, the epoch is omitted when it is 0.
+func rpmVersion(pkg rpmPackage) string {
+	version := fmt.Sprintf("%s-%s", pkg.Version.Ver, pkg.Version.Rel)
+	if pkg.Version.Epoch != "" && pkg.Version.Epoch != "0" {
+		version = pkg.Version.Epoch + ":" + version
+	}
+	return version
+}
+
+// getRpmPrimary reads repodata/primary.xml(.gz) the repomd.xml of the feed points to.
+func getRpmPrimary(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]rpmPackage, error) {
+	repomdURL := rpmRepoURL(progetConfig) + "/repodata/repomd.xml"
+	body, err, _ := getBody(ctx, client, repomdURL, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	var repomd rpmRepomd
+	err = xml.Unmarshal(body, &repomd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", repomdURL, err)
+	}
+
+	var primaryURL string
+	for _, data := range repomd.Data {
+		if data.Type == "primary" {
+			primaryURL, err = resolveURL(rpmRepoURL(progetConfig)+"/", data.Location.Href)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if primaryURL == "" {
+		return nil, fmt.Errorf("%s has no primary metadata", repomdURL)
+	}
+
+	body, err, _ = getBody(ctx, client, primaryURL, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(primaryURL, ".gz") {
+		body, err = gunzip(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack %s: %w", primaryURL, err)
+		}
+	}
+	var primary rpmPrimary
+	err = xml.Unmarshal(body, &primary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", primaryURL, err)
+	}
+	return primary.Packages, nil
+}
+
+func getRpmPackages(ctx context.Context, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	log.Debug().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msg("Getting rpm packages")
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.IterationTimeout) * time.Second,
+	}
+
+	rpms, err := getRpmPrimary(ctx, client, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	_, err = storeRpmIndex(progetConfig, rpms)
+	if err != nil {
+		return nil, err
+	}
+
+	// the architecture is the group, so x86_64 and noarch builds are synced separately
+	var packages []Package
+	positions := make(map[string]int)
+	for _, rpm := range rpms {
+		key := rpm.Arch + ":" + rpm.Name
+		i, exists := positions[key]
+		if !exists {
+			i = len(packages)
+			positions[key] = i
+			packages = append(packages, Package{
+				Group: rpm.Arch,
+				Name:  rpm.Name,
+			})
+		}
+		if version := rpmVersion(rpm); !containsString(packages[i].Versions, version) {
+			packages[i].Versions = append(packages[i].Versions, version)
+		}
+	}
+
+	log.Info().Str("url", progetConfig.URL).Str("feed", progetConfig.Feed).Msgf("Package count: %d", len(packages))
+	return packages, nil
+}
+
+// storeRpmIndex caches the files and publish times of the primary metadata.
+func storeRpmIndex(progetConfig ProgetConfig, rpms []rpmPackage) (*repoIndex, error) {
+	repo := newRepoIndex()
+	for _, rpm := range rpms {
+		version := rpmVersion(rpm)
+		fileURL, err := resolveURL(rpmRepoURL(progetConfig)+"/", rpm.Location.Href)
+		if err != nil {
+			return nil, err
+		}
+		repo.Files[repoVersionKey(rpm.Arch, rpm.Name, version)] = repoFile{
+			Filename:  filepath.Base(rpm.Location.Href),
+			URL:       fileURL,
+			Algorithm: rpm.Checksum.Type,
+			Checksum:  strings.ToLower(strings.TrimSpace(rpm.Checksum.Value)),
+		}
+		if rpm.Time.File > 0 {
+			key := rpm.Arch + ":" + rpm.Name
+			if repo.Published[key] == nil {
+				repo.Published[key] = make(map[string]time.Time)
+			}
+			repo.Published[key][version] = time.Unix(rpm.Time.File, 0)
+		}
+	}
+	storeRepoIndex(progetConfig, repo)
+	return repo, nil
+}
+
+// getRpmIndex returns the metadata of the last listing, reading it when the feed was not listed.
+func getRpmIndex(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig) (*repoIndex, error) {
+	if repo := cachedRepoIndex(progetConfig); repo != nil {
+		return repo, nil
+	}
+	rpms, err := getRpmPrimary(ctx, client, progetConfig, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	return storeRpmIndex(progetConfig, rpms)
+}
+
+// getRpmFile finds the version in the metadata of the last listing. A version
+// missing there, like one just uploaded, is looked up in the current metadata.
+func getRpmFile(ctx context.Context, client *http.Client, progetConfig ProgetConfig, timeoutConfig TimeoutConfig, pkg Package, version string) (repoFile, error) {
+	if file, ok := cachedRepoFile(progetConfig, pkg, version); ok {
+		return file, nil
+	}
+	rpms, err := getRpmPrimary(ctx, client, progetConfig, timeoutConfig)
+	if err != nil {
+		return repoFile{}, err
+	}
+	repo, err := storeRpmIndex(progetConfig, rpms)
+	if err != nil {
+		return repoFile{}, err
+	}
+	if file, ok := repo.Files[repoVersionKey(pkg.Group, pkg.Name, version)]; ok {
+		return file, nil
+	}
+	return repoFile{}, fmt.Errorf("%s/%s:%s not found in %s", pkg.Group, pkg.Name, version, rpmRepoURL(progetConfig))
+}
+
+type rpmDriver struct{}
+
+func (rpmDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getRpmPackages(ctx, feed, timeoutConfig)
+}
+
+func (rpmDriver) CompareVersions(a, b string) int {
+	return compareRpmVersions(a, b)
+}
+
+func (rpmDriver) IsPrerelease(version string) bool {
+	return isTildePrerelease(version)
+}
+
+func (rpmDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	file, err := getRpmFile(ctx, client, feed, timeoutConfig, pkg, version)
+	if err != nil {
+		return nil, err
+	}
+	return downloadRepoFile(ctx, feed, file, dir, timeoutConfig)
+}
+
+func (rpmDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	return uploadWithRetries(ctx, rpmRepoURL(chain.Destination)+"/upload", files[0].Path, chain.Destination, timeoutConfig, putRequest)
+}
+
+func (rpmDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	dest, err := getRpmFile(ctx, client, chain.Destination, timeoutConfig, pkg, version)
+	if err != nil {
+		return "", "", err
+	}
+	log.Info().Str("url", chain.Destination.URL).Str("feed", chain.Destination.Feed).Msgf("Success get hash %s/%s:%s. %s: %s", pkg.Group, pkg.Name, version, dest.Algorithm, dest.Checksum)
+	return repoFileChecksum(files[0].Hash, dest.Algorithm), dest.Checksum, nil
+}
+
+// FeedHash reads the checksum of primary.xml.
+func (rpmDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	file, err := getRpmFile(ctx, client, feed, timeoutConfig, pkg, version)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.ToLower(file.Algorithm), file.Checksum, nil
+}
+
+// Delete removes the package of one architecture, identified by purl.
+func (rpmDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	purl := fmt.Sprintf("pkg:rpm/%s@%s?arch=%s", pkg.Name, url.QueryEscape(version), pkg.Group)
+	if epoch, rest, ok := strings.Cut(version, ":"); ok {
+		purl = fmt.Sprintf("pkg:rpm/%s@%s?arch=%s&epoch=%s", pkg.Name, url.QueryEscape(rest), pkg.Group, epoch)
+	}
+	return deletePackage(ctx, feed, pkg, version, url.Values{"purl": {purl}}, timeoutConfig)
+}
+
+// PublishDates uses the time the package file was added to the repository.
+func (rpmDriver) PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	repo, err := getRpmIndex(ctx, client, feed, timeoutConfig)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Published[pkg.Group+":"+pkg.Name], nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type upackDriver struct{}
+
+func (upackDriver) List(ctx context.Context, feed ProgetConfig, timeoutConfig TimeoutConfig) ([]Package, error) {
+	return getProgetPackages(ctx, feed, timeoutConfig)
+}
+
+func (upackDriver) CompareVersions(a, b string) int {
+	return compareSemver(a, b)
+}
+
+func (upackDriver) IsPrerelease(version string) bool {
+	return isSemverPrerelease(version)
+}
+
+func (upackDriver) Download(ctx context.Context, feed ProgetConfig, pkg Package, version, dir string, timeoutConfig TimeoutConfig) ([]transferFile, error) {
+	downloadURL := cleanURL(fmt.Sprintf("%s/%s/%s/download/%s/%s/%s", feed.URL, feed.Type, feed.Feed, pkg.Group, pkg.Name, version))
+	filePath := filepath.Join(dir, fmt.Sprintf("%s.%s.upack", pkg.Name, version))
+	hash, err := downloadWithRetries(ctx, downloadURL, filePath, feed, timeoutConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []transferFile{{Path: filePath, Hash: hash}}, nil
+}
+
+// Upload rewrites upack.json of remapped packages first and updates the hash in files for Hash.
+func (upackDriver) Upload(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) error {
+	if remapped := chain.remap(pkg); isRemapped(pkg, remapped) {
+		hash, err := rewriteUpackManifest(files[0].Path, remapped)
+		if err != nil {
+			return err
+		}
+		files[0].Hash = hash
+		log.Info().Str("feed", chain.Destination.Feed).Str("Action", "Remap").Msgf("%s/%s:%s is uploaded as %s/%s", pkg.Group, pkg.Name, version, remapped.Group, remapped.Name)
+	}
+	uploadURL := cleanURL(fmt.Sprintf("%s/%s/%s/upload", chain.Destination.URL, chain.Type, chain.Destination.Feed))
+	return uploadWithRetries(ctx, uploadURL, files[0].Path, chain.Destination, timeoutConfig, putRequest)
+}
+
+// Hash compares the sha1 both feeds report for the version. The source
+// package of a remapped version differs, its uploaded file is compared instead.
+func (upackDriver) Hash(ctx context.Context, chain SyncChain, pkg Package, version string, files []transferFile, timeoutConfig TimeoutConfig) (string, string, error) {
+	remapped := chain.remap(pkg)
+	destHashURL := cleanURL(fmt.Sprintf("%s/%s/%s/versions?group=%s&name=%s&version=%s", chain.Destination.URL, chain.Destination.Type, chain.Destination.Feed, remapped.Group, remapped.Name, version))
+	DestHash, err := getPackageHash(ctx, destHashURL, chain.Destination.APIKey, chain.Destination.Feed, remapped.Group, remapped.Name, version, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+	if isRemapped(pkg, remapped) {
+		return files[0].Hash.SHA1, strings.ToLower(DestHash), nil
+	}
+
+	srcHashURL := cleanURL(fmt.Sprintf("%s/%s/%s/versions?group=%s&name=%s&version=%s", chain.Source.URL, chain.Source.Type, chain.Source.Feed, pkg.Group, pkg.Name, version))
+	SrcHash, err := getPackageHash(ctx, srcHashURL, chain.Source.APIKey, chain.Source.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+	if err != nil {
+		return "", "", err
+	}
+	return SrcHash, DestHash, nil
+}
+
+// FeedHash reads the SHA-1 of the versions API.
+func (upackDriver) FeedHash(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (string, string, error) {
+	hashURL := cleanURL(fmt.Sprintf("%s/%s/%s/versions?group=%s&name=%s&version=%s", feed.URL, feed.Type, feed.Feed, pkg.Group, pkg.Name, version))
+	hash, err := getPackageHash(ctx, hashURL, feed.APIKey, feed.Feed, pkg.Group, pkg.Name, version, timeoutConfig)
+	return "sha1", strings.ToLower(hash), err
+}
+
+func (upackDriver) Delete(ctx context.Context, feed ProgetConfig, pkg Package, version string, timeoutConfig TimeoutConfig) (error, int) {
+	return deletePackage(ctx, feed, pkg, version, url.Values{"group": {pkg.Group}, "name": {pkg.Name}, "version": {version}}, timeoutConfig)
+}
+
+// PublishDates reads the published field of the versions endpoint.
+func (upackDriver) PublishDates(ctx context.Context, feed ProgetConfig, pkg Package, timeoutConfig TimeoutConfig) (map[string]time.Time, error) {
+	client := &http.Client{
+		Timeout: time.Duration(timeoutConfig.WebRequestTimeout) * time.Second,
+	}
+	query := url.Values{"group": {pkg.Group}, "name": {pkg.Name}}
+	versionsURL := cleanURL(fmt.Sprintf("%s/%s/%s/versions?%s", feed.URL, feed.Type, feed.Feed, query.Encode()))
+	var versions []struct {
+		Version   string `json:"version"`
+		Published string `json:"published"`
+	}
+	err, _ := getJSON(ctx, client, versionsURL, feed, timeoutConfig, &versions)
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time)
+	for _, v := range versions {
+		if published, ok := parsePublishTime(v.Published); ok {
+			dates[v.Version] = published
+		}
+	}
+	return dates, nil
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+	nugetRegexp  = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-.]+)?$`)
+	pep440Regexp = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)(?:[-_.]?(alpha|a|beta|b|preview|pre|c|rc)[-_.]?(\d+)?)?(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d+)?)?(?:[-_.]?(dev)[-_.]?(\d+)?)?(?:\+[a-z0-9]+(?:[-_.][a-z0-9]+)*)?$`)
+)
+
+// sortVersions orders the versions of every package newest first.
+func sortVersions(driver FeedDriver, packages []Package) {
+	for _, pkg := range packages {
+		versions := pkg.Versions
+		sort.SliceStable(versions, func(i, j int) bool {
+			return driver.CompareVersions(versions[i], versions[j]) > 0
+		})
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareNumeric compares digit strings of any length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareLooseVersions orders strings that follow no known scheme by their
+// numeric and alphabetic runs, so "1.10" is still newer than "1.9".
+func compareLooseVersions(a, b string) int {
+	split := func(s string) []string {
+		var parts []string
+		for _, field := range strings.FieldsFunc(s, func(c rune) bool { return c == '.' || c == '-' || c == '_' || c == '+' }) {
+			start := 0
+			for i := 1; i <= len(field); i++ {
+				if i == len(field) || isNumeric(field[i-1:i]) != isNumeric(field[i:i+1]) {
+					parts = append(parts, field[start:i])
+					start = i
+				}
+			}
+		}
+		return parts
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		var result int
+		switch {
+		case isNumeric(pa[i]) && isNumeric(pb[i]):
+			result = compareNumeric(pa[i], pb[i])
+		case isNumeric(pa[i]):
+			result = 1
+		case isNumeric(pb[i]):
+			result = -1
+		default:
+			result = strings.Compare(pa[i], pb[i])
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return compareInts(len(pa), len(pb))
+}
+
+// comparePrerelease compares dot separated prerelease labels by SemVer 2.0
+// rules. A version without a label is newer than any prerelease.
+func comparePrerelease(a, b string, ignoreCase bool) int {
+	if a == "" || b == "" {
+		return -compareInts(len(a), len(b))
+	}
+	if ignoreCase {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	}
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		var result int
+		switch {
+		case isNumeric(pa[i]) && isNumeric(pb[i]):
+			result = compareNumeric(pa[i], pb[i])
+		case isNumeric(pa[i]):
+			result = -1
+		case isNumeric(pb[i]):
+			result = 1
+		default:
+			result = strings.Compare(pa[i], pb[i])
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return compareInts(len(pa), len(pb))
+}
+
+// compareSemver orders SemVer 2.0 versions, build metadata is ignored.
+func compareSemver(a, b string) int {
+	ma, mb := semverRegexp.FindStringSubmatch(a), semverRegexp.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return compareLooseVersions(a, b)
+	}
+	for i := 1; i <= 3; i++ {
+		if result := compareNumeric(ma[i], mb[i]); result != 0 {
+			return result
+		}
+	}
+	return comparePrerelease(ma[4], mb[4], false)
+}
+
+// compareNugetVersions orders NuGet versions: up to four numeric parts,
+// missing parts are 0, prerelease labels compare case-insensitively.
+func compareNugetVersions(a, b string) int {
+	ma, mb := nugetRegexp.FindStringSubmatch(a), nugetRegexp.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return compareLooseVersions(strings.ToLower(a), strings.ToLower(b))
+	}
+	for i := 1; i <= 4; i++ {
+		if result := compareNumeric(ma[i], mb[i]); result != 0 {
+			return result
+		}
+	}
+	return comparePrerelease(ma[5], mb[5], true)
+}
+
+// normalizeNugetVersion returns the form NuGet v3 feeds list: three numeric
+// parts without leading zeros, the fourth only when it is not 0, a lowercase
+// prerelease label and no build metadata.
+func normalizeNugetVersion(version string) string {
+	m := nugetRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return strings.ToLower(version)
+	}
+	parts := make([]string, 0, 4)
+	for i := 1; i <= 4; i++ {
+		part := strings.TrimLeft(m[i], "0")
+		if part == "" {
+			part = "0"
+		}
+		if i == 4 && part == "0" {
+			break
+		}
+		parts = append(parts, part)
+	}
+	normalized := strings.Join(parts, ".")
+	if m[5] != "" {
+		normalized += "-" + strings.ToLower(m[5])
+	}
+	return normalized
+}
+
+// mavenItem is an element of a parsed Maven version: an int, a qualifier or a sublist.
+type mavenItem struct {
+	kind  int
+	value string
+	items []mavenItem
+}
+
+const (
+	mavenInt = iota
+	mavenString
+	mavenList
+)
+
+var (
+	mavenQualifiers       = []string{"alpha", "beta", "milestone", "rc", "snapshot", "", "sp"}
+	mavenQualifierAliases = map[string]string{"ga": "", "final": "", "release": "", "cr": "rc"}
+	mavenReleaseIndex     = "5"
+)
+
+func mavenStringItem(value string, followedByDigit bool) mavenItem {
+	if followedByDigit && len(value) == 1 {
+		switch value {
+		case "a":
+			value = "alpha"
+		case "b":
+			value = "beta"
+		case "m":
+			value = "milestone"
+		}
+	}
+	if alias, ok := mavenQualifierAliases[value]; ok {
+		value = alias
+	}
+	return mavenItem{kind: mavenString, value: value}
+}
+
+func mavenParseItem(isDigit bool, value string) mavenItem {
+	if isDigit {
+		return mavenItem{kind: mavenInt, value: strings.TrimLeft(value, "0")}
+	}
+	return mavenStringItem(value, false)
+}
+
+func (item mavenItem) isNull() bool {
+	switch item.kind {
+	case mavenInt:
+		return item.value == ""
+	case mavenString:
+		return item.value == ""
+	}
+	return len(item.items) == 0
+}
+
+// parseMavenVersion splits a version the way Maven ComparableVersion does.
+func parseMavenVersion(version string) mavenItem {
+	version = strings.ToLower(version)
+	root := &mavenItem{kind: mavenList}
+	stack := []*mavenItem{root}
+	list := root
+	push := func() {
+		list.items = append(list.items, mavenItem{kind: mavenList})
+		list = &list.items[len(list.items)-1]
+		stack = append(stack, list)
+	}
+
+	isDigit := false
+	start := 0
+	for i := 0; i < len(version); i++ {
+		c := version[i]
+		switch {
+		case c == '.' || c == '-':
+			if i == start {
+				list.items = append(list.items, mavenItem{kind: mavenInt})
+			} else {
+				list.items = append(list.items, mavenParseItem(isDigit, version[start:i]))
+			}
+			start = i + 1
+			if c == '-' {
+				push()
+			}
+		case c >= '0' && c <= '9':
+			if !isDigit && i > start {
+				list.items = append(list.items, mavenStringItem(version[start:i], true))
+				start = i
+				push()
+			}
+			isDigit = true
+		default:
+			if isDigit && i > start {
+				list.items = append(list.items, mavenParseItem(true, version[start:i]))
+				start = i
+				push()
+			}
+			isDigit = false
+		}
+	}
+	if len(version) > start {
+		list.items = append(list.items, mavenParseItem(isDigit, version[start:]))
+	}
+
+	// normalize removes trailing null items, innermost lists first
+	for i := len(stack) - 1; i >= 0; i-- {
+		items := stack[i].items
+		for j := len(items) - 1; j >= 0; j-- {
+			if items[j].isNull() {
+				items = append(items[:j], items[j+1:]...)
+			} else if items[j].kind != mavenList {
+				break
+			}
+		}
+		stack[i].items = items
+	}
+	return *root
+}
+
+func mavenComparableQualifier(qualifier string) string {
+	for i, known := range mavenQualifiers {
+		if known == qualifier {
+			return strconv.Itoa(i)
+		}
+	}
+	return strconv.Itoa(len(mavenQualifiers)) + "-" + qualifier
+}
+
+// compareMavenItems compares a with b, b is nil when the other version has no item at this position.
+func compareMavenItems(a mavenItem, b *mavenItem) int {
+	switch a.kind {
+	case mavenInt:
+		if b == nil {
+			if a.value == "" {
+				return 0
+			}
+			return 1
+		}
+		if b.kind == mavenInt {
+			return compareNumeric(a.value, b.value)
+		}
+		return 1
+	case mavenString:
+		if b == nil {
+			return strings.Compare(mavenComparableQualifier(a.value), mavenReleaseIndex)
+		}
+		switch b.kind {
+		case mavenInt, mavenList:
+			return -1
+		}
+		return strings.Compare(mavenComparableQualifier(a.value), mavenComparableQualifier(b.value))
+	}
+
+	if b == nil {
+		if len(a.items) == 0 {
+			return 0
+		}
+		return compareMavenItems(a.items[0], nil)
+	}
+	switch b.kind {
+	case mavenInt:
+		return -1
+	case mavenString:
+		return 1
+	}
+	for i := 0; i < len(a.items) || i < len(b.items); i++ {
+		var result int
+		switch {
+		case i >= len(a.items):
+			result = -compareMavenItems(b.items[i], nil)
+		case i >= len(b.items):
+			result = compareMavenItems(a.items[i], nil)
+		default:
+			result = compareMavenItems(a.items[i], &b.items[i])
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// compareMavenVersions orders versions like Maven ComparableVersion:
+// alpha < beta < milestone < rc < snapshot < release < sp.
+func compareMavenVersions(a, b string) int {
+	pb := parseMavenVersion(b)
+	return compareMavenItems(parseMavenVersion(a), &pb)
+}
+
+type pep440Version struct {
+	epoch   string
+	release []string
+	pre     [2]string
+	post    string
+	dev     string
+	hasPre  bool
+	hasPost bool
+	hasDev  bool
+}
+
+func parsePep440(version string) (pep440Version, bool) {
+	m := pep440Regexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(version)))
+	if m == nil {
+		return pep440Version{}, false
+	}
+	v := pep440Version{epoch: m[1], release: strings.Split(m[2], ".")}
+	for len(v.release) > 1 && strings.TrimLeft(v.release[len(v.release)-1], "0") == "" {
+		v.release = v.release[:len(v.release)-1]
+	}
+	if m[3] != "" {
+		v.hasPre = true
+		switch m[3] {
+		case "alpha", "a":
+			v.pre[0] = "0"
+		case "beta", "b":
+			v.pre[0] = "1"
+		default:
+			v.pre[0] = "2"
+		}
+		v.pre[1] = m[4]
+	}
+	if m[5] != "" || m[6] != "" {
+		v.hasPost = true
+		v.post = m[5] + m[7]
+	}
+	if m[8] != "" {
+		v.hasDev = true
+		v.dev = m[9]
+	}
+	return v, true
+}
+
+// comparePep440 orders PyPI versions by PEP 440, local versions are ignored.
+func comparePep440(a, b string) int {
+	va, okA := parsePep440(a)
+	vb, okB := parsePep440(b)
+	if !okA || !okB {
+		return compareLooseVersions(strings.ToLower(a), strings.ToLower(b))
+	}
+	if result := compareNumeric(va.epoch, vb.epoch); result != 0 {
+		return result
+	}
+	for i := 0; i < len(va.release) || i < len(vb.release); i++ {
+		var x, y string
+		if i < len(va.release) {
+			x = va.release[i]
+		}
+		if i < len(vb.release) {
+			y = vb.release[i]
+		}
+		if result := compareNumeric(x, y); result != 0 {
+			return result
+		}
+	}
+
+	// 1.0.dev1 < 1.0a1 < 1.0 < 1.0.post1, a dev release precedes its base
+	preRank := func(v pep440Version) int {
+		switch {
+		case v.hasPre:
+			return 1
+		case !v.hasPost && v.hasDev:
+			return 0
+		}
+		return 2
+	}
+	if result := compareInts(preRank(va), preRank(vb)); result != 0 {
+		return result
+	}
+	if va.hasPre && vb.hasPre {
+		if result := strings.Compare(va.pre[0], vb.pre[0]); result != 0 {
+			return result
+		}
+		if result := compareNumeric(va.pre[1], vb.pre[1]); result != 0 {
+			return result
+		}
+	}
+	if va.hasPost != vb.hasPost {
+		if va.hasPost {
+			return 1
+		}
+		return -1
+	}
+	if result := compareNumeric(va.post, vb.post); result != 0 {
+		return result
+	}
+	if va.hasDev != vb.hasDev {
+		if va.hasDev {
+			return -1
+		}
+		return 1
+	}
+	return compareNumeric(va.dev, vb.dev)
+}
+
+// compareDebianVersions orders [epoch:]upstream[-revision] like dpkg.
+func compareDebianVersions(a, b string) int {
+	split := func(version string) (string, string, string) {
+		epoch := "0"
+		if i := strings.Index(version, ":"); i >= 0 {
+			epoch, version = version[:i], version[i+1:]
+		}
+		revision := ""
+		if i := strings.LastIndex(version, "-"); i >= 0 {
+			version, revision = version[:i], version[i+1:]
+		}
+		return epoch, version, revision
+	}
+	ea, ua, ra := split(a)
+	eb, ub, rb := split(b)
+	if result := compareNumeric(ea, eb); result != 0 {
+		return result
+	}
+	if result := compareDpkgPart(ua, ub); result != 0 {
+		return result
+	}
+	return compareDpkgPart(ra, rb)
+}
+
+// dpkgOrder ranks a character of the non-digit part: "~" before the end of
+// the string, letters before other characters.
+func dpkgOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	}
+	return int(c) + 256
+}
+
+func compareDpkgPart(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigitByte(a[0])) || (b != "" && !isDigitByte(b[0])) {
+			var ca, cb int
+			if a != "" && !isDigitByte(a[0]) {
+				ca = dpkgOrder(a[0])
+			}
+			if b != "" && !isDigitByte(b[0]) {
+				cb = dpkgOrder(b[0])
+			}
+			if ca != cb {
+				return compareInts(ca, cb)
+			}
+			if a != "" && !isDigitByte(a[0]) {
+				a = a[1:]
+			}
+			if b != "" && !isDigitByte(b[0]) {
+				b = b[1:]
+			}
+		}
+		na, nb := leadingDigits(a), leadingDigits(b)
+		if result := compareNumeric(na, nb); result != 0 {
+			return result
+		}
+		a, b = a[len(na):], b[len(nb):]
+	}
+	return 0
+}
+
+// compareRpmVersions orders [epoch:]version-release like rpm.
+func compareRpmVersions(a, b string) int {
+	split := func(version string) (string, string, string) {
+		epoch := "0"
+		if i := strings.Index(version, ":"); i >= 0 {
+			epoch, version = version[:i], version[i+1:]
+		}
+		release := ""
+		if i := strings.LastIndex(version, "-"); i >= 0 {
+			version, release = version[:i], version[i+1:]
+		}
+		return epoch, version, release
+	}
+	ea, va, ra := split(a)
+	eb, vb, rb := split(b)
+	if result := compareNumeric(ea, eb); result != 0 {
+		return result
+	}
+	if result := rpmvercmp(va, vb); result != 0 {
+		return result
+	}
+	return rpmvercmp(ra, rb)
+}
+
+// rpmvercmp compares alphanumeric segments, numeric segments are newer than
+// alphabetic ones, "~" sorts before and "^" after the end of a version.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isAlnum := func(c byte) bool {
+		return isDigitByte(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for a != "" || b != "" {
+		for a != "" && !isAlnum(a[0]) && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for b != "" && !isAlnum(b[0]) && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case !strings.HasPrefix(a, "^"):
+				return 1
+			case !strings.HasPrefix(b, "^"):
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		var sa, sb string
+		if isDigitByte(a[0]) {
+			sa, sb = leadingDigits(a), leadingDigits(b)
+			if sb == "" {
+				return 1
+			}
+			a, b = a[len(sa):], b[len(sb):]
+			if result := compareNumeric(sa, sb); result != 0 {
+				return result
+			}
+			continue
+		}
+		sa, sb = leadingLetters(a), leadingLetters(b)
+		if sb == "" {
+			return -1
+		}
+		a, b = a[len(sa):], b[len(sb):]
+		if result := strings.Compare(sa, sb); result != 0 {
+			return result
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && isDigitByte(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func leadingLetters(s string) string {
+	i := 0
+	for i < len(s) && (s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z') {
+		i++
+	}
+	return s[:i]
+}
+
+// isSemverPrerelease reports a prerelease label. Versions that are not SemVer
+// are prereleases when they have a "-" suffix, like npm and upack treat them.
+func isSemverPrerelease(version string) bool {
+	m := semverRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return strings.Contains(version, "-")
+	}
+	return m[4] != ""
+}
+
+func isNugetPrerelease(version string) bool {
+	m := nugetRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return strings.Contains(version, "-")
+	}
+	return m[5] != ""
+}
+
+// isMavenPrerelease reports a qualifier ordered before the release: alpha, beta, milestone, rc or snapshot.
+func isMavenPrerelease(version string) bool {
+	var walk func(item mavenItem) bool
+	walk = func(item mavenItem) bool {
+		switch item.kind {
+		case mavenString:
+			return mavenComparableQualifier(item.value) < mavenReleaseIndex
+		case mavenList:
+			for _, child := range item.items {
+				if walk(child) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return walk(parseMavenVersion(version))
+}
+
+// isPep440Prerelease reports pre and dev releases.
+func isPep440Prerelease(version string) bool {
+	v, ok := parsePep440(version)
+	return ok && (v.hasPre || v.hasDev)
+}
+
+// isTildePrerelease reports the "~" debian and rpm versions use for prereleases.
+func isTildePrerelease(version string) bool {
+	return strings.Contains(version, "~")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		driver FeedDriver
+		a, b   string
+		want   int
+	}{
+		// SemVer: prerelease < release, identifiers compare numerically, build metadata is ignored
+		{npmDriver{}, "1.0.0-alpha", "1.0.0", -1},
+		{npmDriver{}, "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{npmDriver{}, "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{npmDriver{}, "1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{npmDriver{}, "1.0.0-rc.1", "1.0.0", -1},
+		{npmDriver{}, "1.0.0+build.1", "1.0.0+build.2", 0},
+		{npmDriver{}, "1.0.0-rc.1+build.5", "1.0.0-rc.1", 0},
+		{npmDriver{}, "1.10.0", "1.9.0", 1},
+		{npmDriver{}, "2.0.0", "10.0.0", -1},
+		{upackDriver{}, "v1.2.3", "1.2.3", 0},
+		{helmDriver{}, "0.1.0-rc.1", "0.1.0", -1},
+
+		// NuGet: four numeric parts, missing parts are 0, labels are case-insensitive
+		{nugetDriver{}, "1.0.0.1", "1.0.0", 1},
+		{nugetDriver{}, "1.0", "1.0.0.0", 0},
+		{nugetDriver{}, "1.2.3.4", "1.2.3.10", -1},
+		{nugetDriver{}, "1.0.0-Beta", "1.0.0-beta", 0},
+		{nugetDriver{}, "1.0.0.1-rc", "1.0.0.1", -1},
+		{nugetDriver{}, "2.0.0+abc", "2.0.0", 0},
+
+		// Maven: alpha < beta < milestone < rc < SNAPSHOT < release < sp
+		{mavenDriver{}, "1.0-SNAPSHOT", "1.0", -1},
+		{mavenDriver{}, "1.0", "1.0-sp1", -1},
+		{mavenDriver{}, "1.0-SNAPSHOT", "1.0-sp1", -1},
+		{mavenDriver{}, "1.0-alpha-1", "1.0-beta-1", -1},
+		{mavenDriver{}, "1.0-beta-1", "1.0-milestone-1", -1},
+		{mavenDriver{}, "1.0-M1", "1.0-RC1", -1},
+		{mavenDriver{}, "1.0-rc1", "1.0", -1},
+		{mavenDriver{}, "1.0-rc1", "1.0-SNAPSHOT", -1},
+		{mavenDriver{}, "1.0-cr1", "1.0-rc1", 0},
+		{mavenDriver{}, "1.0-ga", "1.0", 0},
+		{mavenDriver{}, "1.0.0", "1", 0},
+		{mavenDriver{}, "1.10", "1.9", 1},
+		{mavenDriver{}, "1.0-RC1-SNAPSHOT", "1.0-RC1", -1},
+
+		// PEP 440: dev < a < b < rc < final < post, epochs first
+		{pypiDriver{}, "1.0.dev0", "1.0a1", -1},
+		{pypiDriver{}, "1.0a1", "1.0b1", -1},
+		{pypiDriver{}, "1.0b1", "1.0rc1", -1},
+		{pypiDriver{}, "1.0rc1", "1.0", -1},
+		{pypiDriver{}, "1.0", "1.0.post1", -1},
+		{pypiDriver{}, "1.0a1.dev1", "1.0a1", -1},
+		{pypiDriver{}, "1.0.post1.dev1", "1.0.post1", -1},
+		{pypiDriver{}, "1.0", "1.0.0", 0},
+		{pypiDriver{}, "1.0-alpha1", "1.0a1", 0},
+		{pypiDriver{}, "1!1.0", "2.0", 1},
+		{pypiDriver{}, "1.0.dev0", "0.9", 1},
+
+		// dpkg: epoch first, "~" sorts before anything including the end of the version
+		{debianDriver{}, "1.0~rc1", "1.0", -1},
+		{debianDriver{}, "1.0~~", "1.0~", -1},
+		{debianDriver{}, "1.0", "1.0+b1", -1},
+		{debianDriver{}, "1:0.9", "1.0", 1},
+		{debianDriver{}, "1.0-1", "1.0-2", -1},
+		{debianDriver{}, "1.0-1ubuntu1", "1.0-1", 1},
+		{debianDriver{}, "2.10-1", "2.9-1", 1},
+
+		// rpm: "~" sorts before the end of the version, "^" after it but before more parts
+		{rpmDriver{}, "1.0~rc1", "1.0", -1},
+		{rpmDriver{}, "1.0", "1.0^git1", -1},
+		{rpmDriver{}, "1.0^git1", "1.0.1", -1},
+		{rpmDriver{}, "1.0~rc1", "1.0^git1", -1},
+		{rpmDriver{}, "1:1.0-1", "2.0-1", 1},
+		{rpmDriver{}, "1.0-1.el8", "1.0-2.el8", -1},
+		{rpmDriver{}, "1.0a", "1.0", 1},
+		{rpmDriver{}, "1.10", "1.9", 1},
+	}
+	for _, tt := range tests {
+		got := sign(tt.driver.CompareVersions(tt.a, tt.b))
+		if got != tt.want {
+			t.Errorf("%T.CompareVersions(%q, %q) = %d, want %d", tt.driver, tt.a, tt.b, got, tt.want)
+		}
+		if reverse := sign(tt.driver.CompareVersions(tt.b, tt.a)); reverse != -tt.want {
+			t.Errorf("%T.CompareVersions(%q, %q) = %d, want %d", tt.driver, tt.b, tt.a, reverse, -tt.want)
+		}
+	}
+}
+
+func TestIsPrerelease(t *testing.T) {
+	tests := []struct {
+		driver  FeedDriver
+		version string
+		want    bool
+	}{
+		{npmDriver{}, "1.0.0-rc.1", true},
+		{npmDriver{}, "1.0.0", false},
+		{npmDriver{}, "1.0.0+build.1", false},
+		{nugetDriver{}, "1.0.0.1-beta", true},
+		{nugetDriver{}, "1.0.0.1", false},
+		{mavenDriver{}, "1.0-SNAPSHOT", true},
+		{mavenDriver{}, "1.0-RC1", true},
+		{mavenDriver{}, "1.0-sp1", false},
+		{mavenDriver{}, "1.0", false},
+		{pypiDriver{}, "1.0.dev0", true},
+		{pypiDriver{}, "1.0a1", true},
+		{pypiDriver{}, "1.0.post1", false},
+		{debianDriver{}, "1.0~rc1-1", true},
+		{debianDriver{}, "1.0-1", false},
+		{rpmDriver{}, "1.0~rc1", true},
+		{rpmDriver{}, "1.0^git1", false},
+		{dockerDriver{}, "1.0.0-rc.1", true},
+		{dockerDriver{}, "latest", false},
+	}
+	for _, tt := range tests {
+		if got := tt.driver.IsPrerelease(tt.version); got != tt.want {
+			t.Errorf("%T.IsPrerelease(%q) = %v, want %v", tt.driver, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	packages := []Package{{Name: "lib", Versions: []string{"1.0-SNAPSHOT", "1.0-sp1", "0.9", "1.0", "1.0-rc1"}}}
+	sortVersions(mavenDriver{}, packages)
+	want := []string{"1.0-sp1", "1.0", "1.0-SNAPSHOT", "1.0-rc1", "0.9"}
+	if !reflect.DeepEqual(packages[0].Versions, want) {
+		t.Errorf("sortVersions = %v, want %v", packages[0].Versions, want)
+	}
+}
+
+func TestNormalizeNugetVersion(t *testing.T) {
+	tests := map[string]string{
+		"1.0":           "1.0.0",
+		"1.0.0.0":       "1.0.0",
+		"1.0.0.1":       "1.0.0.1",
+		"01.002.3":      "1.2.3",
+		"1.0.0-Beta":    "1.0.0-beta",
+		"1.0-RC.1+abc":  "1.0.0-rc.1",
+		"2.0.0+build.5": "2.0.0",
+		"not-a-Version": "not-a-version",
+	}
+	for version, want := range tests {
+		if got := normalizeNugetVersion(version); got != want {
+			t.Errorf("normalizeNugetVersion(%q) = %q, want %q", version, got, want)
+		}
+	}
+}